@@ -0,0 +1,85 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+)
+
+// callTypes are the node types that are "called", as opposed to tables,
+// fields etc that are referenced.
+var callTypes = []string{
+	ntMethod.String(),
+	ntPubProc.String(),
+	ntForm.String(),
+	ntReport.String(),
+}
+
+// writeCallTree writes an indented tree, like the unix tree command, of
+// everything the root calls, recursively.  Calls back to something already
+// on the current branch are marked as cycles.  Unless repeat is set, the
+// calls of anything already shown elsewhere in the tree are not shown again,
+// so long as they were shown, and not cut off by maxDepth.
+func (g *graph) writeCallTree(w io.Writer, root nodeId, maxDepth int, filter typeFilter, repeat bool) {
+	onBranch := make(map[nodeId]bool)
+	shown := make(map[nodeId]bool)
+
+	describe := func(id nodeId) string {
+		desc := fmt.Sprintf("%s (%s)", g.label(id), id.Type)
+		if _, ok := g.nodes[id]; !ok {
+			desc += " [missing]"
+		}
+		return desc
+	}
+
+	var walk func(id nodeId, prefix string, depth int)
+	walk = func(id nodeId, prefix string, depth int) {
+		if maxDepth != 0 && depth >= maxDepth {
+			return
+		}
+		n, ok := g.nodes[id]
+		if !ok {
+			return
+		}
+
+		var calls []nodeId
+		for _, ref := range n.refsSorted() {
+			if filter.shows(ref.Type) {
+				calls = append(calls, ref)
+			}
+		}
+
+		for i, call := range calls {
+			branch, indent := "├── ", "│   "
+			if i == len(calls)-1 {
+				branch, indent = "└── ", "    "
+			}
+
+			switch {
+			case onBranch[call]:
+				fmt.Fprintf(w, "%s%s%s [cycle]\n", prefix, branch, describe(call))
+			case shown[call] && !repeat && g.calls(call):
+				fmt.Fprintf(w, "%s%s%s [see above]\n", prefix, branch, describe(call))
+			default:
+				fmt.Fprintf(w, "%s%s%s\n", prefix, branch, describe(call))
+				// Only once its calls are shown can it be referred back to
+				if maxDepth == 0 || depth+1 < maxDepth {
+					shown[call] = true
+				}
+				onBranch[call] = true
+				walk(call, prefix+indent, depth+1)
+				onBranch[call] = false
+			}
+		}
+	}
+
+	fmt.Fprintln(w, describe(root))
+	onBranch[root] = true
+	shown[root] = true
+	walk(root, "", 0)
+}
+
+// calls reports whether the node references anything at all.
+func (g *graph) calls(id nodeId) bool {
+	n, ok := g.nodes[id]
+	return ok && len(n.Refs) != 0
+}
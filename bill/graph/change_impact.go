@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// changedFiles lists the files that differ between two revisions of the
+// source root, as paths within the source root.  Renames are listed as the
+// old and new paths, so modules that were renamed away are seen as deleted.
+func changedFiles(sourceRoot string, from string, to string) ([]string, error) {
+	// -z, as otherwise paths with unusual characters are quoted
+	cmd := exec.Command("git", "diff", "--name-only", "-z", "--no-renames", "--relative", from, to)
+	cmd.Dir = sourceRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s..%s : %w", from, to, err)
+	}
+
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, filepath.FromSlash(f))
+		}
+	}
+	return files, nil
+}
+
+// relativeFiles keys the source files by their path within the source root,
+// as git reports them, rather than by wherever that copy of the source is.
+func (g *graph) relativeFiles(sourceRoot string) error {
+	files := make(map[string][]nodeId, len(g.files))
+	for path, ids := range g.files {
+		rel, err := filepath.Rel(sourceRoot, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = ids
+	}
+	g.files = files
+	return nil
+}
+
+// changedNodes returns the nodes defined in the changed files after the
+// change, the nodes defined in them before the change that no longer exist
+// at all (i.e. deleted modules), and the files that define nothing either
+// before or after, e.g. because they are not modules.
+func changedNodes(before *graph, after *graph, files []string) ([]nodeId, []nodeId, []string) {
+	var changed []nodeId
+	var deleted []nodeId
+	var unknown []string
+	for _, f := range files {
+		f = filepath.Clean(f)
+		defined := after.files[f]
+		changed = append(changed, defined...)
+
+		var gone []nodeId
+		for _, id := range before.files[f] {
+			if _, ok := after.nodes[id]; !ok {
+				gone = append(gone, id)
+			}
+		}
+		deleted = append(deleted, gone...)
+
+		if len(defined) == 0 && len(gone) == 0 {
+			unknown = append(unknown, f)
+		}
+	}
+
+	byId := func(a, b nodeId) int { return strings.Compare(a.id(), b.id()) }
+	slices.SortFunc(changed, byId)
+	slices.SortFunc(deleted, byId)
+	return slices.Compact(changed), slices.Compact(deleted), unknown
+}
+
+// changeImpact returns every module that calls one of the changed (or
+// deleted) modules, directly or transitively, with its distance from the
+// nearest change, and the entry points that are either changed or affected.
+func (g *graph) changeImpact(changed []nodeId) (map[nodeId]int, []nodeId, error) {
+	modules, err := onlyTypesFilter(moduleTypes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	affected := g.reach(changed, up, 0, modules)
+	// Changed modules that are part of a cycle reach themselves
+	for _, id := range changed {
+		delete(affected, id)
+	}
+
+	var entryPoints []nodeId
+	for _, id := range g.entryPointsSorted() {
+		_, isAffected := affected[id]
+		if isAffected || slices.Contains(changed, id) {
+			entryPoints = append(entryPoints, id)
+		}
+	}
+
+	return affected, entryPoints, nil
+}
+
+func (g *graph) writeChangeImpact(w io.Writer, before *graph, changed []nodeId, deleted []nodeId, unknown []string, affected map[nodeId]int, entryPoints []nodeId) {
+	fmt.Fprintf(w, "changed : %d modules\n", len(changed))
+	for _, id := range changed {
+		fmt.Fprintf(w, "\t%s\t%s\n", id.Type, g.label(id))
+	}
+
+	if len(deleted) != 0 {
+		fmt.Fprintf(w, "deleted : %d modules\n", len(deleted))
+		for _, id := range deleted {
+			fmt.Fprintf(w, "\t%s\t%s\n", id.Type, before.label(id))
+		}
+	}
+
+	if len(unknown) != 0 {
+		fmt.Fprintf(w, "changed files not in the graph (not modules) : %d\n", len(unknown))
+		for _, f := range unknown {
+			fmt.Fprintf(w, "\t%s\n", f)
+		}
+	}
+
+	fmt.Fprintf(w, "affected callers : %d modules\n", len(affected))
+	for _, id := range sortedByDepth(affected) {
+		fmt.Fprintf(w, "\t%d\t%s\t%s\n", affected[id], id.Type, g.label(id))
+	}
+
+	fmt.Fprintf(w, "affected entry points : %d\n", len(entryPoints))
+	for _, id := range entryPoints {
+		fmt.Fprintf(w, "\t%s\t%s\t%s\n", id.Type, g.label(id), g.entryPoints[id])
+	}
+}
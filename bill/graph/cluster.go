@@ -0,0 +1,293 @@
+package graph
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// weightedGraph is an undirected weighted graph used for community
+// detection.  adj is symmetric, and adj[i][i] holds twice the weight of the
+// edges internal to node i (so that the degree of i is the sum of adj[i]).
+type weightedGraph struct {
+	adj []map[int]float64
+}
+
+func newWeightedGraph(n int) *weightedGraph {
+	wg := &weightedGraph{adj: make([]map[int]float64, n)}
+	for i := range wg.adj {
+		wg.adj[i] = make(map[int]float64)
+	}
+	return wg
+}
+
+// addEdge adds an undirected edge between i and j.
+func (wg *weightedGraph) addEdge(i int, j int, w float64) {
+	wg.adj[i][j] += w
+	wg.adj[j][i] += w
+}
+
+func (wg *weightedGraph) degree(i int) float64 {
+	d := 0.0
+	for _, w := range wg.adj[i] {
+		d += w
+	}
+	return d
+}
+
+// neighboursSorted returns the neighbours of i, in order, for determinism.
+func (wg *weightedGraph) neighboursSorted(i int) []int {
+	ns := make([]int, 0, len(wg.adj[i]))
+	for j := range wg.adj[i] {
+		ns = append(ns, j)
+	}
+	sort.Ints(ns)
+	return ns
+}
+
+// louvain detects communities using the Louvain modularity optimisation
+// method.  It returns the community of each node, with communities numbered
+// from zero.  Higher resolution gives more, smaller, communities.
+func (wg *weightedGraph) louvain(resolution float64) []int {
+	n := len(wg.adj)
+
+	// membership of the original nodes
+	membership := make([]int, n)
+	for i := range membership {
+		membership[i] = i
+	}
+
+	current := wg
+	for {
+		community, moved := current.localMoves(resolution)
+		if !moved {
+			break
+		}
+		community = renumber(community)
+		if slices.Max(community)+1 == len(current.adj) {
+			// Nodes moved, but ended up with no fewer communities
+			break
+		}
+		for i := range membership {
+			membership[i] = community[membership[i]]
+		}
+		current = current.aggregate(community)
+	}
+
+	return renumber(membership)
+}
+
+// localMoves repeatedly moves single nodes to the neighbouring community
+// giving the best modularity gain, until no moves improve modularity.
+func (wg *weightedGraph) localMoves(resolution float64) ([]int, bool) {
+	n := len(wg.adj)
+	community := make([]int, n)
+	degree := make([]float64, n)
+	total := make([]float64, n) // total degree of each community
+	m2 := 0.0
+	for i := range n {
+		community[i] = i
+		degree[i] = wg.degree(i)
+		total[i] = degree[i]
+		m2 += degree[i]
+	}
+	if m2 == 0 {
+		return community, false
+	}
+
+	moved := false
+	for improved := true; improved; {
+		improved = false
+		for i := range n {
+			// weights from i to each neighbouring community
+			weights := make(map[int]float64)
+			var candidates []int
+			for _, j := range wg.neighboursSorted(i) {
+				if j == i {
+					continue
+				}
+				c := community[j]
+				if _, ok := weights[c]; !ok {
+					candidates = append(candidates, c)
+				}
+				weights[c] += wg.adj[i][j]
+			}
+
+			old := community[i]
+			total[old] -= degree[i]
+
+			gain := func(c int) float64 {
+				return weights[c] - resolution*total[c]*degree[i]/m2
+			}
+
+			best := old
+			bestGain := gain(old)
+			for _, c := range candidates {
+				if g := gain(c); g > bestGain {
+					best = c
+					bestGain = g
+				}
+			}
+
+			community[i] = best
+			total[best] += degree[i]
+			if best != old {
+				improved = true
+				moved = true
+			}
+		}
+	}
+	return community, moved
+}
+
+// aggregate builds a new graph with one node per community.
+func (wg *weightedGraph) aggregate(community []int) *weightedGraph {
+	count := 0
+	for _, c := range community {
+		count = max(count, c+1)
+	}
+	agg := newWeightedGraph(count)
+	for i, neighbours := range wg.adj {
+		for j, w := range neighbours {
+			agg.adj[community[i]][community[j]] += w
+		}
+	}
+	return agg
+}
+
+// renumber renumbers communities from zero, in order of first appearance.
+func renumber(community []int) []int {
+	numbers := make(map[int]int)
+	renumbered := make([]int, len(community))
+	for i, c := range community {
+		num, ok := numbers[c]
+		if !ok {
+			num = len(numbers)
+			numbers[c] = num
+		}
+		renumbered[i] = num
+	}
+	return renumbered
+}
+
+// cluster is a proposed group of nodes, e.g. a subsystem.
+type cluster struct {
+	name    string
+	members []nodeId
+}
+
+// clusters detects communities of nodes of the given types, treating
+// references as undirected.  Clusters are returned largest first, and each
+// is named after its most connected member.
+func (g *graph) clusters(include func(nodeId) bool, resolution float64) ([]*cluster, map[nodeId]int) {
+	a := g.newAdjacency(include)
+
+	wg := newWeightedGraph(len(a.ids))
+	for i, out := range a.out {
+		for _, j := range out {
+			if i != j {
+				wg.addEdge(i, j, 1)
+			}
+		}
+	}
+
+	membership := wg.louvain(resolution)
+
+	var clusters []*cluster
+	for i, c := range membership {
+		for len(clusters) <= c {
+			clusters = append(clusters, &cluster{})
+		}
+		clusters[c].members = append(clusters[c].members, a.ids[i])
+	}
+
+	for _, c := range clusters {
+		best := -1.0
+		for _, id := range c.members {
+			if d := wg.degree(a.index[id]); d > best {
+				best = d
+				c.name = g.label(id)
+			}
+		}
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].members) > len(clusters[j].members) })
+
+	clusterOf := make(map[nodeId]int)
+	for i, c := range clusters {
+		for _, id := range c.members {
+			clusterOf[id] = i
+		}
+	}
+
+	return clusters, clusterOf
+}
+
+func (g *graph) printClusters(include func(nodeId) bool, resolution float64, listEdges bool) {
+	clusters, clusterOf := g.clusters(include, resolution)
+
+	for i, c := range clusters {
+		fmt.Printf("cluster %d (%s) : %d nodes\n", i+1, c.name, len(c.members))
+		for _, id := range c.members {
+			fmt.Printf("\t%s (%s)\n", g.label(id), id.Type)
+		}
+	}
+
+	// Count, and optionally list, the references between clusters
+	type clusterPair struct{ from, to int }
+	counts := make(map[clusterPair]int)
+	edges := make(map[clusterPair][][2]nodeId)
+	for _, n := range g.nodesSorted() {
+		from, ok := clusterOf[n.nodeId]
+		if !ok {
+			continue
+		}
+		for _, ref := range n.refsSorted() {
+			to, ok := clusterOf[ref]
+			if !ok || from == to {
+				continue
+			}
+			pair := clusterPair{from, to}
+			counts[pair]++
+			edges[pair] = append(edges[pair], [2]nodeId{n.nodeId, ref})
+		}
+	}
+
+	pairs := make([]clusterPair, 0, len(counts))
+	for pair := range counts {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if counts[pairs[i]] != counts[pairs[j]] {
+			return counts[pairs[i]] > counts[pairs[j]]
+		}
+		if pairs[i].from != pairs[j].from {
+			return pairs[i].from < pairs[j].from
+		}
+		return pairs[i].to < pairs[j].to
+	})
+
+	fmt.Println("cross cluster references :")
+	for _, pair := range pairs {
+		fmt.Printf("\tcluster %d (%s) -> cluster %d (%s) : %d references\n",
+			pair.from+1, clusters[pair.from].name, pair.to+1, clusters[pair.to].name, counts[pair])
+		if listEdges {
+			for _, edge := range edges[pair] {
+				fmt.Printf("\t\t%s\n", g.formatPath(edge[:]))
+			}
+		}
+	}
+}
+
+// applyCommunities detects communities of modules and tables, and assigns
+// each node to a group named after its community.
+func (g *graph) applyCommunities() {
+	include, _ := nodeTypesFilter(nil)
+	clusters, _ := g.clusters(include, 1.0)
+	for i, c := range clusters {
+		group := fmt.Sprintf("cluster %d (%s)", i+1, c.name)
+		for _, id := range c.members {
+			g.setProperties(id, properties{"group": group})
+		}
+	}
+}
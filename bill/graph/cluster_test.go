@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLouvainSeparatesTriangles(t *testing.T) {
+	assert := assert.New(t)
+
+	// Two triangles joined by a single edge
+	wg := newWeightedGraph(6)
+	wg.addEdge(0, 1, 1)
+	wg.addEdge(1, 2, 1)
+	wg.addEdge(2, 0, 1)
+	wg.addEdge(3, 4, 1)
+	wg.addEdge(4, 5, 1)
+	wg.addEdge(5, 3, 1)
+	wg.addEdge(2, 3, 1)
+
+	assert.Equal([]int{0, 0, 0, 1, 1, 1}, wg.louvain(1.0))
+}
+
+func TestClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("a"), newNodeId("t1", ntTable)},
+		[2]nodeId{method("b"), newNodeId("t1", ntTable)},
+		[2]nodeId{method("c"), method("d")},
+		[2]nodeId{method("c"), newNodeId("t2", ntTable)},
+		[2]nodeId{method("d"), newNodeId("t2", ntTable)},
+		[2]nodeId{method("d"), method("a")},
+	)
+
+	include, err := nodeTypesFilter(nil)
+	assert.NoError(err)
+
+	clusters, clusterOf := g.clusters(include, 1.0)
+	assert.Len(clusters, 2)
+	assert.Equal(clusterOf[method("a")], clusterOf[newNodeId("t1", ntTable)])
+	assert.Equal(clusterOf[method("c")], clusterOf[newNodeId("t2", ntTable)])
+	assert.NotEqual(clusterOf[method("a")], clusterOf[method("c")])
+}
@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// deadCodeReport is a single tabular report of unused things.
+type deadCodeReport struct {
+	name    string
+	columns []string
+	rows    [][]string
+}
+
+func (r *deadCodeReport) add(row ...string) {
+	r.rows = append(r.rows, row)
+}
+
+// deadCode produces the unused tables, indexes, public procedures, fields,
+// methods, forms and reports reports.  With liveness, everything that is not
+// live is reported, otherwise only things that are not referenced.
+func (g *graph) deadCode(liveness bool) []*deadCodeReport {
+	referencedBy := g.referencedBy()
+
+	// referencedOtherThan reports whether anything other than the given node
+	// types references the node
+	referencedOtherThan := func(id nodeId, types ...nodeType) bool {
+		for _, from := range referencedBy[id] {
+			if !slices.Contains(types, from.Type) {
+				return true
+			}
+		}
+		return false
+	}
+
+	tables := &deadCodeReport{name: "unused_tables", columns: []string{"table_name"}}
+	indexes := &deadCodeReport{name: "unused_indexes", columns: []string{"table_name", "index_name"}}
+	pubProcs := &deadCodeReport{name: "unused_pp", columns: []string{"public_procedure_name"}}
+	fields := &deadCodeReport{name: "unused_fields", columns: []string{"table_name", "field_name"}}
+	methods := &deadCodeReport{name: "unused_methods", columns: []string{"method_name"}}
+	forms := &deadCodeReport{name: "unused_forms", columns: []string{"form_name"}}
+	reports := &deadCodeReport{name: "unused_reports", columns: []string{"report_name"}}
+
+	for _, n := range g.nodesSorted() {
+		_, used := g.used[n.nodeId]
+		referenced := len(referencedBy[n.nodeId]) != 0
+
+		if liveness {
+			// Treat live as used and referenced, so the rules below
+			// report everything that isn't live.
+			_, used = g.live[n.nodeId]
+			referenced = used
+			if used {
+				continue
+			}
+		}
+
+		switch n.Type {
+		case ntTable:
+			if liveness || !referencedOtherThan(n.nodeId, ntField, ntIndex) {
+				tables.add(n.Label)
+			}
+		case ntIndex:
+			if !referenced {
+				for _, table := range n.refsSorted() {
+					if table.Type == ntTable {
+						indexes.add(g.label(table), n.Label)
+					}
+				}
+			}
+		case ntField:
+			if !referenced {
+				for _, table := range n.refsSorted() {
+					if table.Type == ntTable {
+						fields.add(g.label(table), n.Label)
+					}
+				}
+			}
+		case ntPubProc:
+			if !referenced && !used {
+				pubProcs.add(n.Label)
+			}
+		case ntMethod:
+			if !referenced && !used {
+				methods.add(n.Label)
+			}
+		case ntForm:
+			if !referenced && !used {
+				forms.add(n.Label)
+			}
+		case ntReport:
+			if !referenced && !used {
+				reports.add(n.Label)
+			}
+		}
+	}
+
+	// Order in the same way as the original cypher queries
+	for _, r := range []*deadCodeReport{indexes, fields} {
+		slices.SortStableFunc(r.rows, func(a, b []string) int {
+			return slices.Compare(a, b)
+		})
+	}
+
+	return []*deadCodeReport{tables, indexes, pubProcs, fields, methods, forms, reports}
+}
+
+func writeDeadCodeReports(reports []*deadCodeReport, outputDir string, format string) error {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		var err error
+		switch format {
+		case "csv":
+			err = r.writeCsv(filepath.Join(outputDir, r.name+".csv"))
+		case "json":
+			err = r.writeJson(filepath.Join(outputDir, r.name+".json"))
+		default:
+			return fmt.Errorf("unknown dead code output format : '%s'", format)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *deadCodeReport) writeCsv(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(r.columns); err != nil {
+		return err
+	}
+	if err := w.WriteAll(r.rows); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (r *deadCodeReport) writeJson(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := make([]map[string]string, 0, len(r.rows))
+	for _, row := range r.rows {
+		entry := make(map[string]string)
+		for i, col := range r.columns {
+			entry[col] = row[i]
+		}
+		entries = append(entries, entry)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		return err
+	}
+	return f.Close()
+}
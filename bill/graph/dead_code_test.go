@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeadCode covers the rules of the cypher queries in unused.sh, which
+// deadCode replaces.
+func TestDeadCode(t *testing.T) {
+	table := func(name string) nodeId { return newNodeId(name, ntTable) }
+	field := func(name string) nodeId { return newNodeId(name, ntField) }
+	index := func(name string) nodeId { return newNodeId(name, ntIndex) }
+	pubProc := func(name string) nodeId { return newNodeId(name, ntPubProc) }
+
+	for _, tc := range []struct {
+		name   string
+		refs   [][2]nodeId
+		used   []nodeId
+		report string
+		want   [][]string
+	}{
+		{
+			// Only references from something other than the table's own
+			// fields and indexes count
+			name: "tables referenced only through their fields or indexes",
+			refs: [][2]nodeId{
+				{field("code"), table("tb")},
+				{index("tb_code"), table("tb")},
+				{method("m"), field("code")},
+				{method("m"), index("tb_code")},
+				{index("ta_id"), table("ta")},
+				{method("m"), table("tc")},
+			},
+			report: "unused_tables",
+			want:   [][]string{{"ta"}, {"tb"}},
+		},
+		{
+			name: "indexes without referrers, by table then index",
+			refs: [][2]nodeId{
+				{index("tb_id"), table("tb")},
+				{index("ta_name"), table("ta")},
+				{index("ta_code"), table("ta")},
+				{index("ta_used"), table("ta")},
+				{method("m"), index("ta_used")},
+			},
+			report: "unused_indexes",
+			want:   [][]string{{"ta", "ta_code"}, {"ta", "ta_name"}, {"tb", "tb_id"}},
+		},
+		{
+			// A field name shared by tables is a single node, reported
+			// for each table
+			name: "fields without referrers, by table then field",
+			refs: [][2]nodeId{
+				{field("name"), table("tb")},
+				{field("code"), table("tb")},
+				{field("code"), table("ta")},
+				{field("used"), table("ta")},
+				{method("m"), field("used")},
+			},
+			report: "unused_fields",
+			want:   [][]string{{"ta", "code"}, {"tb", "code"}, {"tb", "name"}},
+		},
+		{
+			name: "public procedures neither referenced nor used",
+			refs: [][2]nodeId{
+				{pubProc("zz"), method("m")},
+				{pubProc("called"), method("m")},
+				{pubProc("aa"), method("m")},
+				{pubProc("system"), method("m")},
+				{method("m"), pubProc("called")},
+			},
+			used:   []nodeId{pubProc("system")},
+			report: "unused_pp",
+			want:   [][]string{{"aa"}, {"zz"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := testGraph(tc.refs...)
+			for _, id := range tc.used {
+				g.used[id] = struct{}{}
+			}
+
+			var got [][]string
+			for _, r := range g.deadCode(false) {
+				if r.name == tc.report {
+					got = r.rows
+				}
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestWriteDeadCodeReports(t *testing.T) {
+	assert := assert.New(t)
+
+	reports := []*deadCodeReport{
+		{name: "unused_tables", columns: []string{"table_name"}},
+		{name: "unused_fields", columns: []string{"table_name", "field_name"}, rows: [][]string{{"ginv", "code"}, {"ginv", `say "hi", x`}}},
+	}
+
+	read := func(filename string) string {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	dir := filepath.Join(t.TempDir(), "out")
+	assert.NoError(writeDeadCodeReports(reports, dir, "csv"))
+	assert.Equal("table_name\n", read(filepath.Join(dir, "unused_tables.csv")))
+	assert.Equal("table_name,field_name\nginv,code\nginv,\"say \"\"hi\"\", x\"\n", read(filepath.Join(dir, "unused_fields.csv")))
+
+	assert.NoError(writeDeadCodeReports(reports, dir, "json"))
+	assert.Equal("[]\n", read(filepath.Join(dir, "unused_tables.json")))
+	assert.Equal(`[
+  {
+    "field_name": "code",
+    "table_name": "ginv"
+  },
+  {
+    "field_name": "say \"hi\", x",
+    "table_name": "ginv"
+  }
+]
+`, read(filepath.Join(dir, "unused_fields.json")))
+
+	assert.ErrorContains(writeDeadCodeReports(reports, dir, "xml"), "unknown dead code output format : 'xml'")
+}
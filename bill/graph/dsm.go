@@ -0,0 +1,362 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dsm is a dependency structure matrix.  counts[i][j] is the number of
+// references from group i to group j.  Groups are in dependency order,
+// leaves first, so references above the diagonal go against the layering
+// and indicate cycles between groups.
+type dsm struct {
+	names  []string
+	counts [][]int
+}
+
+// grouper assigns a node to a named group for aggregation, or reports false
+// if the node should be left out.
+type grouper func(id nodeId) (string, bool)
+
+func moduleGrouper(g *graph) grouper {
+	include, _ := nodeTypesFilter(nil)
+	return func(id nodeId) (string, bool) {
+		if !include(id) {
+			return "", false
+		}
+		return fmt.Sprintf("%s (%s)", g.label(id), id.Type), true
+	}
+}
+
+func moduleTypeGrouper(id nodeId) (string, bool) {
+	include, _ := nodeTypesFilter(nil)
+	if !include(id) {
+		return "", false
+	}
+	return id.Type.String(), true
+}
+
+// groupPattern is a user defined group name pattern, see userGrouper.
+type groupPattern struct {
+	group    string
+	nodeType nodeType
+	name     string
+}
+
+// parseGroups parses user defined groups, mapping group name to a list of
+// patterns.
+func parseGroups(groups map[string][]string) ([]groupPattern, error) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var patterns []groupPattern
+	for _, name := range names {
+		for _, p := range groups[name] {
+			pat := groupPattern{group: name, name: strings.ToLower(p)}
+			if t, n, ok := strings.Cut(p, ":"); ok {
+				nt, err := parseNodeType(t)
+				if err != nil {
+					return nil, err
+				}
+				pat.nodeType = nt
+				pat.name = strings.ToLower(n)
+			}
+			if _, err := path.Match(pat.name, ""); err != nil {
+				return nil, fmt.Errorf("bad pattern '%s' for group '%s' : %w", p, name, err)
+			}
+			patterns = append(patterns, pat)
+		}
+	}
+	return patterns, nil
+}
+
+// groupsOf returns the distinct groups with a pattern matching the node, in
+// name order.
+func groupsOf(patterns []groupPattern, id nodeId) []string {
+	var groups []string
+	for _, p := range patterns {
+		if p.nodeType != "" && p.nodeType != id.Type {
+			continue
+		}
+		if ok, _ := path.Match(p.name, id.Name); ok && !slices.Contains(groups, p.group) {
+			groups = append(groups, p.group)
+		}
+	}
+	return groups
+}
+
+// userGrouper loads user defined groups from a JSON file mapping group name
+// to a list of patterns.  Patterns are matched (see path.Match) against
+// lower case node names, and may be prefixed with a node type and a colon,
+// e.g. "table:ginv*".  Nodes matching no group are left out, and it is an
+// error for any node in the graph to match more than one group.
+func userGrouper(g *graph, groupsJson string) (grouper, error) {
+	f, err := os.Open(groupsJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open groups JSON file : %w", err)
+	}
+	defer f.Close()
+
+	var groups map[string][]string
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&groups); err != nil {
+		return nil, fmt.Errorf("failed to decode groups JSON file : %w", err)
+	}
+
+	patterns, err := parseGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check everything that could be grouped, including missing nodes
+	var overlaps []string
+	checked := make(map[nodeId]struct{})
+	check := func(id nodeId) {
+		if _, ok := checked[id]; ok {
+			return
+		}
+		checked[id] = struct{}{}
+		if groups := groupsOf(patterns, id); len(groups) > 1 {
+			overlaps = append(overlaps, fmt.Sprintf("%s (%s) is in %s", g.label(id), id.Type, strings.Join(groups, ", ")))
+		}
+	}
+	for _, n := range g.nodesSorted() {
+		check(n.nodeId)
+		for _, ref := range n.refsSorted() {
+			check(ref)
+		}
+	}
+	if len(overlaps) > 0 {
+		return nil, fmt.Errorf("%s : %d nodes match more than one group : %s", groupsJson, len(overlaps), strings.Join(overlaps, "; "))
+	}
+
+	return func(id nodeId) (string, bool) {
+		if groups := groupsOf(patterns, id); len(groups) > 0 {
+			return groups[0], true
+		}
+		return "", false
+	}, nil
+}
+
+// applyGroups assigns each node to a group, as a node property.
+func (g *graph) applyGroups(groupOf grouper) {
+	for id := range g.nodes {
+		if group, ok := groupOf(id); ok {
+			g.setProperties(id, properties{"group": group})
+		}
+	}
+}
+
+// dsm aggregates the references in the graph into groups.
+func (g *graph) dsm(groupOf grouper) *dsm {
+	// Build a graph of the groups, so they can be put in dependency order
+	groups := newGraph()
+	counts := make(map[[2]nodeId]int)
+	groupNode := func(name string) *node {
+		id := newNodeId(name, ntGroup)
+		n, ok := groups.nodes[id]
+		if !ok {
+			n = newNode()
+			n.nodeId = id
+			n.Label = name
+			groups.nodes[id] = n
+		}
+		return n
+	}
+
+	for _, n := range g.nodesSorted() {
+		fromName, ok := groupOf(n.nodeId)
+		if !ok {
+			continue
+		}
+		from := groupNode(fromName)
+		for _, ref := range n.refsSorted() {
+			toName, ok := groupOf(ref)
+			if !ok {
+				continue
+			}
+			to := groupNode(toName)
+			from.Refs[to.nodeId] = struct{}{}
+			counts[[2]nodeId{from.nodeId, to.nodeId}]++
+		}
+	}
+
+	var order []nodeId
+	for _, layer := range groups.layers(func(nodeId) bool { return true }) {
+		for _, component := range layer {
+			order = append(order, component...)
+		}
+	}
+
+	m := &dsm{
+		names:  make([]string, len(order)),
+		counts: make([][]int, len(order)),
+	}
+	for i, from := range order {
+		m.names[i] = groups.label(from)
+		m.counts[i] = make([]int, len(order))
+		for j, to := range order {
+			m.counts[i][j] = counts[[2]nodeId{from, to}]
+		}
+	}
+	return m
+}
+
+func (m *dsm) writeCsv(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(append([]string{""}, m.names...)); err != nil {
+		return err
+	}
+	for i, name := range m.names {
+		row := []string{name}
+		for _, c := range m.counts[i] {
+			row = append(row, strconv.Itoa(c))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var dsmTemplate = template.Must(template.New("dsm").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Dependency structure matrix</title>
+<style>
+body { font-family: sans-serif; font-size: 12px; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 2px 4px; text-align: center; min-width: 1.5em; }
+th.row { text-align: left; white-space: nowrap; }
+td.diagonal { background-color: #999; }
+</style>
+</head>
+<body>
+<h1>Dependency structure matrix</h1>
+<p>Each row lists the references from that group to the group in each column.  Groups are in dependency
+order, so references below the diagonal (blue) follow the layering, and references above the diagonal
+(red) go against it, indicating a cycle between groups.</p>
+<table>
+<tr><th></th>{{range .Columns}}<th title="{{.Name}}">{{.Number}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th class="row">{{.Number}}. {{.Name}}</th>{{range .Cells}}<td{{if .Diagonal}} class="diagonal"{{else if .Count}} style="background-color: {{.Colour}}"{{end}} title="{{.Title}}">{{if .Count}}{{.Count}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (m *dsm) writeHtml(filename string) error {
+	type cell struct {
+		Count    int
+		Diagonal bool
+		Colour   template.CSS
+		Title    string
+	}
+	type row struct {
+		Number int
+		Name   string
+		Cells  []cell
+	}
+	type column struct {
+		Number int
+		Name   string
+	}
+
+	maxCount := 0
+	for _, r := range m.counts {
+		for _, c := range r {
+			maxCount = max(maxCount, c)
+		}
+	}
+
+	data := struct {
+		Columns []column
+		Rows    []row
+	}{}
+	for i, name := range m.names {
+		data.Columns = append(data.Columns, column{i + 1, name})
+		r := row{Number: i + 1, Name: name}
+		for j, c := range m.counts[i] {
+			// Log scale, so a few heavily used groups don't hide the rest
+			intensity := 0.0
+			if maxCount > 0 {
+				intensity = 0.15 + 0.85*math.Log1p(float64(c))/math.Log1p(float64(maxCount))
+			}
+			colour := fmt.Sprintf("rgba(0, 0, 255, %.2f)", intensity)
+			if j > i {
+				colour = fmt.Sprintf("rgba(255, 0, 0, %.2f)", intensity)
+			}
+			r.Cells = append(r.Cells, cell{
+				Count:    c,
+				Diagonal: i == j,
+				Colour:   template.CSS(colour),
+				Title:    fmt.Sprintf("%s -> %s : %d", name, m.names[j], c),
+			})
+		}
+		data.Rows = append(data.Rows, r)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := dsmTemplate.Execute(f, data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (g *graph) writeDsm(level string, groupsJson string, outputDir string) error {
+	var groupOf grouper
+	switch level {
+	case "module":
+		groupOf = moduleGrouper(g)
+	case "module-type":
+		groupOf = moduleTypeGrouper
+	case "group":
+		if groupsJson == "" {
+			return fmt.Errorf("a groups JSON file is required for the group level")
+		}
+		var err error
+		if groupOf, err = userGrouper(g, groupsJson); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown DSM level : '%s'", level)
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+
+	m := g.dsm(groupOf)
+	if err := m.writeCsv(filepath.Join(outputDir, "dsm.csv")); err != nil {
+		return err
+	}
+	return m.writeHtml(filepath.Join(outputDir, "dsm.html"))
+}
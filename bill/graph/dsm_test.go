@@ -0,0 +1,102 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dsmTestGraph() *graph {
+	return testGraph(
+		[2]nodeId{newNodeId("custform", ntForm), method("nrg_sweep2")},
+		[2]nodeId{method("nrg_sweep2"), newNodeId("ginv", ntTable)},
+		[2]nodeId{method("nrg_sweep2"), method("nrg_calc")},
+		[2]nodeId{method("nrg_calc"), newNodeId("ginv", ntTable)},
+		[2]nodeId{method("nrg_calc"), newNodeId("custform", ntForm)},
+	)
+}
+
+func TestUserGrouper(t *testing.T) {
+	assert := assert.New(t)
+
+	g := dsmTestGraph()
+
+	groupOf, err := userGrouper(g, writeTestFile(t, "groups.json", `{"ui": ["form:*"], "energy": ["NRG_*"], "data": ["table:ginv*"]}`))
+	assert.NoError(err)
+	for id, want := range map[nodeId]string{
+		newNodeId("custform", ntForm): "ui",
+		method("nrg_sweep2"):          "energy",
+		newNodeId("ginv", ntTable):    "data",
+	} {
+		group, ok := groupOf(id)
+		assert.True(ok)
+		assert.Equal(want, group, id.id())
+	}
+	_, ok := groupOf(method("other"))
+	assert.False(ok)
+
+	// A pattern with a type only matches nodes of that type
+	_, ok = groupOf(method("ginv"))
+	assert.False(ok)
+
+	_, err = userGrouper(g, writeTestFile(t, "groups.json", `{"energy": ["nrg_*"], "sweeps": ["method:*sweep*"]}`))
+	assert.ErrorContains(err, "nrg_sweep2 (method) is in energy, sweeps")
+
+	_, err = userGrouper(g, writeTestFile(t, "groups.json", `{"bad": ["nosuchtype:x"]}`))
+	assert.Error(err)
+
+	_, err = userGrouper(g, writeTestFile(t, "groups.json", `{"bad": ["[x"]}`))
+	assert.Error(err)
+}
+
+func TestDsm(t *testing.T) {
+	assert := assert.New(t)
+
+	g := dsmTestGraph()
+	groupOf, err := userGrouper(g, writeTestFile(t, "groups.json", `{"ui": ["form:*"], "calc": ["nrg_calc"], "sweep": ["nrg_sweep*"], "data": ["table:*"]}`))
+	assert.NoError(err)
+
+	// Leaves first, with the ui -> sweep -> calc -> ui cycle in name order
+	m := g.dsm(groupOf)
+	assert.Equal([]string{"data", "calc", "sweep", "ui"}, m.names)
+	assert.Equal([][]int{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 1, 0, 0},
+		{0, 0, 1, 0},
+	}, m.counts)
+
+	m = g.dsm(moduleTypeGrouper)
+	assert.Equal([]string{"table", "form", "method"}, m.names)
+	assert.Equal([][]int{
+		{0, 0, 0},
+		{0, 0, 1},
+		{2, 1, 1},
+	}, m.counts)
+}
+
+func TestWriteDsm(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(dsmTestGraph().writeDsm("module-type", "", dir))
+
+	csv, err := os.ReadFile(filepath.Join(dir, "dsm.csv"))
+	assert.NoError(err)
+	assert.Equal(`,table,form,method
+table,0,0,0
+form,0,0,1
+method,2,1,1
+`, string(csv))
+
+	html, err := os.ReadFile(filepath.Join(dir, "dsm.html"))
+	assert.NoError(err)
+	// Above the diagonal is red, below is blue
+	assert.Contains(string(html), `<td style="background-color: rgba(255, 0, 0, 0.69)" title="form -&gt; method : 1">1</td>`)
+	assert.Contains(string(html), `<td style="background-color: rgba(0, 0, 255, 1.00)" title="method -&gt; table : 2">2</td>`)
+
+	assert.Error(dsmTestGraph().writeDsm("group", "", dir))
+	assert.Error(dsmTestGraph().writeDsm("nonsense", "", dir))
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"fmt"
+)
+
+// subgraph returns a new graph containing only the given nodes, and the
+// references between them.
+func (g *graph) subgraph(keep map[nodeId]struct{}) *graph {
+	s := newGraph()
+	for id := range keep {
+		n, ok := g.nodes[id]
+		if !ok {
+			// Missing nodes are implied by references to them
+			continue
+		}
+		sn := newNode()
+		sn.nodeId = n.nodeId
+		sn.Label = n.Label
+		sn.Txt = n.Txt
+		for ref := range n.Refs {
+			if _, ok := keep[ref]; ok {
+				sn.Refs[ref] = struct{}{}
+				if props, ok := g.refProps[[2]nodeId{id, ref}]; ok {
+					s.refProps[[2]nodeId{id, ref}] = props
+				}
+			}
+		}
+		s.nodes[id] = sn
+	}
+	s.inheritFrom(g)
+	return s
+}
+
+// focus returns the neighbourhood of the named node, up to depth references
+// away (zero for unlimited) in the given direction [up|down|both].  If types
+// are given, only nodes of those types are included or traversed.
+func (g *graph) focus(name string, nodeType string, depth int, dir string, types []string) (*graph, error) {
+	var dirs []direction
+	switch dir {
+	case "down":
+		dirs = []direction{down}
+	case "up":
+		dirs = []direction{up}
+	case "both":
+		dirs = []direction{up, down}
+	default:
+		return nil, fmt.Errorf("unknown direction : '%s'", dir)
+	}
+
+	filter, err := onlyTypesFilter(types)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := g.findNode(name, nodeType)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[nodeId]struct{}{start: {}}
+	for _, d := range dirs {
+		for id := range g.reach([]nodeId{start}, d, depth, filter) {
+			keep[id] = struct{}{}
+		}
+	}
+
+	return g.subgraph(keep), nil
+}
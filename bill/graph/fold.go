@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"fmt"
+)
+
+// Abstraction levels for the graph output
+const (
+	levelFull        = "full"
+	levelModuleTable = "module+table"
+	levelModule      = "module"
+)
+
+// fold returns a new graph at the given abstraction level.  At the
+// module+table level fields and indexes are folded into the tables they
+// belong to, and work areas into the modules that use them.  At the module
+// level tables are also folded into the modules that use them.  Parallel
+// references that result from folding are merged, with a count of the
+// underlying references.
+func (g *graph) fold(level string) (*graph, error) {
+	var folded func(nt nodeType) bool
+	switch level {
+	case levelFull, "":
+		return g, nil
+	case levelModuleTable:
+		folded = func(nt nodeType) bool {
+			return nt == ntField || nt == ntIndex || nt == ntWorkArea
+		}
+	case levelModule:
+		folded = func(nt nodeType) bool {
+			return nt == ntField || nt == ntIndex || nt == ntWorkArea || nt == ntTable
+		}
+	default:
+		return nil, fmt.Errorf("unknown graph level : '%s'", level)
+	}
+
+	// targets returns what a reference to id from the node n becomes after
+	// folding.  Fields and indexes reference the table they belong to;
+	// anything else that is folded goes into the referencing module, so the
+	// reference is lost.
+	targets := func(n *node, id nodeId) []nodeId {
+		if !folded(id.Type) {
+			return []nodeId{id}
+		}
+		if (id.Type != ntField && id.Type != ntIndex) || folded(ntTable) {
+			return nil
+		}
+		return g.ownerTables(n, id)
+	}
+
+	f := newGraph()
+	counts := make(map[[2]nodeId]int)
+	for _, n := range g.nodesSorted() {
+		if folded(n.Type) {
+			continue
+		}
+
+		fn := newNode()
+		fn.nodeId = n.nodeId
+		fn.Label = n.Label
+		fn.Txt = n.Txt
+		f.nodes[n.nodeId] = fn
+
+		// makeIndexRefsAlsoTable adds a reference to the table of each
+		// index referenced, which would otherwise be counted twice
+		viaIndex := make(map[nodeId]struct{})
+		for ref := range n.Refs {
+			if ref.Type == ntIndex {
+				for _, table := range targets(n, ref) {
+					viaIndex[table] = struct{}{}
+				}
+			}
+		}
+
+		for _, ref := range n.refsSorted() {
+			if _, ok := viaIndex[ref]; ok {
+				continue
+			}
+			for _, to := range targets(n, ref) {
+				if to == n.nodeId && ref != n.nodeId {
+					continue
+				}
+				fn.Refs[to] = struct{}{}
+				counts[[2]nodeId{n.nodeId, to}]++
+			}
+		}
+	}
+
+	for e, count := range counts {
+		f.refProps[e] = properties{"count": count}
+	}
+
+	f.inheritFrom(g)
+
+	return f, nil
+}
+
+// ownerTables returns the tables that the field or index id, referenced from
+// the node n, belongs to.  Modules refer to fields and indexes by name alone,
+// and a name may belong to more than one table, in which case only the tables
+// that n also references directly are taken to be the owners, unless that
+// leaves none, when it is unknown which is meant.
+func (g *graph) ownerTables(n *node, id nodeId) []nodeId {
+	fn, ok := g.nodes[id]
+	if !ok {
+		return nil
+	}
+	var tables, referenced []nodeId
+	for _, ref := range fn.refsSorted() {
+		if ref.Type != ntTable {
+			continue
+		}
+		tables = append(tables, ref)
+		if _, ok := n.Refs[ref]; ok {
+			referenced = append(referenced, ref)
+		}
+	}
+	if len(tables) > 1 && len(referenced) != 0 {
+		return referenced
+	}
+	return tables
+}
+
+// inheritFrom carries over everything else known about the nodes in this
+// graph from the original graph g.
+func (f *graph) inheritFrom(g *graph) {
+	for id := range f.nodes {
+		if _, ok := g.used[id]; ok {
+			f.used[id] = struct{}{}
+		}
+		if reason, ok := g.entryPoints[id]; ok {
+			f.entryPoints[id] = reason
+		}
+		if _, ok := g.live[id]; ok {
+			f.live[id] = struct{}{}
+		}
+		if props, ok := g.props[id]; ok {
+			f.setProperties(id, props)
+		}
+	}
+	for call, count := range g.observed {
+		_, fromOk := f.nodes[call[0]]
+		_, toOk := f.nodes[call[1]]
+		if fromOk && toOk {
+			f.observed[call] = count
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFold(t *testing.T) {
+	assert := assert.New(t)
+
+	table := newNodeId("t", ntTable)
+	g := testGraph(
+		[2]nodeId{newNodeId("f1", ntField), table},
+		[2]nodeId{newNodeId("f2", ntField), table},
+		[2]nodeId{method("a"), newNodeId("f1", ntField)},
+		[2]nodeId{method("a"), newNodeId("f2", ntField)},
+		[2]nodeId{method("a"), newNodeId("w", ntWorkArea)},
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), table},
+	)
+
+	f, err := g.fold(levelModuleTable)
+	assert.NoError(err)
+	assert.Len(f.nodes, 3)
+	assert.Equal(map[nodeId]struct{}{method("b"): {}, table: {}}, f.nodes[method("a")].Refs)
+	assert.Equal(properties{"count": 2}, f.refProps[[2]nodeId{method("a"), table}])
+	assert.Equal(properties{"count": 1}, f.refProps[[2]nodeId{method("b"), table}])
+
+	f, err = g.fold(levelModule)
+	assert.NoError(err)
+	assert.Len(f.nodes, 2)
+	assert.Equal(map[nodeId]struct{}{method("b"): {}}, f.nodes[method("a")].Refs)
+	assert.Empty(f.nodes[method("b")].Refs)
+
+	_, err = g.fold("nonsense")
+	assert.Error(err)
+}
+
+func TestFoldSharedFieldNames(t *testing.T) {
+	assert := assert.New(t)
+
+	ta := newNodeId("ta", ntTable)
+	tb := newNodeId("tb", ntTable)
+	code := newNodeId("code", ntField)
+	g := testGraph(
+		[2]nodeId{code, ta},
+		[2]nodeId{code, tb},
+		[2]nodeId{newNodeId("ta_id", ntIndex), ta},
+		[2]nodeId{newNodeId("ta_name", ntField), ta},
+		[2]nodeId{method("a"), code},
+		[2]nodeId{method("a"), newNodeId("ta_id", ntIndex)},
+		[2]nodeId{method("b"), code},
+		[2]nodeId{method("c"), code},
+		[2]nodeId{method("c"), newNodeId("ta_name", ntField)},
+		[2]nodeId{method("c"), tb},
+	)
+	g.makeIndexRefsAlsoTable()
+
+	f, err := g.fold(levelModuleTable)
+	assert.NoError(err)
+
+	// The field is resolved to the table a also uses, through the index,
+	// and the table reference added for the index is not counted again
+	assert.Equal(map[nodeId]struct{}{ta: {}}, f.nodes[method("a")].Refs)
+	assert.Equal(properties{"count": 2}, f.refProps[[2]nodeId{method("a"), ta}])
+
+	// With nothing else to go on, the field could be either table's
+	assert.Equal(map[nodeId]struct{}{ta: {}, tb: {}}, f.nodes[method("b")].Refs)
+	assert.Equal(properties{"count": 1}, f.refProps[[2]nodeId{method("b"), ta}])
+	assert.Equal(properties{"count": 1}, f.refProps[[2]nodeId{method("b"), tb}])
+
+	// c references tb directly, so the field is tb's
+	assert.Equal(map[nodeId]struct{}{ta: {}, tb: {}}, f.nodes[method("c")].Refs)
+	assert.Equal(properties{"count": 1}, f.refProps[[2]nodeId{method("c"), ta}])
+	assert.Equal(properties{"count": 2}, f.refProps[[2]nodeId{method("c"), tb}])
+}
+
+func TestFocus(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("b"), newNodeId("t", ntTable)},
+		[2]nodeId{method("c"), method("missing")},
+		[2]nodeId{method("x"), method("a")},
+	)
+
+	f, err := g.focus("b", "", 1, "both", nil)
+	assert.NoError(err)
+	assert.Len(f.nodes, 4)
+	assert.Equal(map[nodeId]struct{}{method("b"): {}}, f.nodes[method("a")].Refs)
+	assert.Empty(f.nodes[method("c")].Refs)
+	assert.NotContains(f.nodes, method("x"))
+
+	f, err = g.focus("b", "", 0, "down", []string{"method"})
+	assert.NoError(err)
+	assert.Len(f.nodes, 2)
+	assert.Equal(map[nodeId]struct{}{method("c"): {}}, f.nodes[method("b")].Refs)
+	assert.Equal(map[nodeId]struct{}{method("missing"): {}}, f.nodes[method("c")].Refs)
+}
@@ -1,11 +1,14 @@
 package graph
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
 func PublicProcs(sourceRoot string) error {
@@ -44,33 +47,550 @@ func listNodeType(sourceRoot string, nodeType nodeType) error {
 	return nil
 }
 
-func Graph(sourceRoot string, output string, moduleCsv string, modudetCsv string, schemaDumpJson string, specialJson string) error {
+// defaultSpecialJson is the special JSON used if none is given, and it
+// exists.
+const defaultSpecialJson = "./special.json"
+
+// GraphSource describes where the data used to build a graph comes from.
+// Only SourceRoot is required, the rest are optional.
+type GraphSource struct {
+	SourceRoot     string
+	ModulesCsv     string
+	ModudetCsv     string
+	SchemaDumpJson string
+	// SpecialJson is the special cases JSON, defaultSpecialJson (if it
+	// exists) if empty
+	SpecialJson string
+	// UsageSince is the start of the ModuDet usage window, as a date or a
+	// period before now (see parseSince), or empty for all time
+	UsageSince string
+	// UnknownLogicIds is what to do about ModuDet logic ids that are not in
+	// ModuleS [error|warn|ignore], warn if empty.  Only for the CSV files.
+	UnknownLogicIds string
+	// UsageDsn, if set, is the Bill Postgres mirror to read module usage
+	// from, instead of ModulesCsv and ModudetCsv
+	UsageDsn string
+}
+
+// hasUsage reports whether module usage is read, from either CSV files or a
+// DSN.
+func (src GraphSource) hasUsage() bool {
+	return src.ModudetCsv != "" || src.UsageDsn != ""
+}
+
+// GraphOptions control what is included in the generated graph.
+type GraphOptions struct {
+	// Metrics includes structural metrics as node properties
+	Metrics bool
+	// MetricsSamples, if non zero, is the number of nodes to sample when
+	// estimating betweenness, rather than computing it exactly
+	MetricsSamples int
+	// Level is the abstraction level [full|module+table|module]
+	Level string
+	// Focus, if set, restricts the graph to the neighbourhood of the named
+	// node, of FocusType if the name is ambiguous
+	Focus     string
+	FocusType string
+	// Depth limits the neighbourhood to this many references away from the
+	// focus, zero for unlimited
+	Depth int
+	// Direction of references to follow from the focus [up|down|both]
+	Direction string
+	// Types, if set, restricts the neighbourhood to these node types
+	Types []string
+	// Cluster groups nodes in the dot output [type|group|community]
+	Cluster string
+	// GroupsJson defines the groups when clustering by group (see dsm)
+	GroupsJson string
+	// Styled styles the dot output for presentation
+	Styled bool
+}
+
+func Graph(src GraphSource, output string, opts GraphOptions) error {
+
+	switch opts.Cluster {
+	case "", "type", "community":
+	case "group":
+		if opts.GroupsJson == "" {
+			return fmt.Errorf("a groups JSON file is required to cluster by group")
+		}
+	default:
+		return fmt.Errorf("unknown clustering : '%s'", opts.Cluster)
+	}
+	if output != "dot" && (opts.Cluster != "" || opts.Styled) {
+		return fmt.Errorf("clustering and styling are only supported by the dot output, not '%s'", output)
+	}
 
 	var graphOutput graphOutput
 	switch output {
 	case "neo":
 		graphOutput = &NeoGraphOutput{}
 	case "dot":
-		graphOutput = &DotGraphOutput{}
+		dotCluster := opts.Cluster
+		if dotCluster == "community" {
+			// communities are assigned as groups
+			dotCluster = "group"
+		}
+		graphOutput = &DotGraphOutput{Cluster: dotCluster, Styled: opts.Styled}
+	case "mermaid":
+		graphOutput = &MermaidGraphOutput{}
+	case "plantuml":
+		graphOutput = &PlantUMLGraphOutput{}
 	default:
 		return fmt.Errorf("unknown graph output : '%s'", output)
 	}
 
-	graph := newGraph()
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	if opts.Metrics {
+		graph.applyMetrics(opts.MetricsSamples)
+	}
+
+	graph, err = graph.fold(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	if opts.Focus != "" {
+		graph, err = graph.focus(opts.Focus, opts.FocusType, opts.Depth, opts.Direction, opts.Types)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Groups are node properties, so are only applied when clustering the
+	// dot output (which doesn't otherwise write properties), and never end
+	// up in the neo output
+	switch opts.Cluster {
+	case "group":
+		groupOf, err := userGrouper(graph, opts.GroupsJson)
+		if err != nil {
+			return err
+		}
+		graph.applyGroups(groupOf)
+	case "community":
+		graph.applyCommunities()
+	}
+
+	return graph.writeGraph(graphOutput)
+}
+
+// Uses prints everything the named node references, directly or
+// transitively.
+func Uses(src GraphSource, name string, nodeType string, depth int, include []string, exclude []string) error {
+	return reachability(src, name, nodeType, depth, include, exclude, down)
+}
+
+// UsedBy prints everything that references the named node, directly or
+// transitively.
+func UsedBy(src GraphSource, name string, nodeType string, depth int, include []string, exclude []string) error {
+	return reachability(src, name, nodeType, depth, include, exclude, up)
+}
+
+func reachability(src GraphSource, name string, nodeType string, depth int, include []string, exclude []string, dir direction) error {
+	filter, err := newTypeFilter(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	start, err := graph.findNode(name, nodeType)
+	if err != nil {
+		return err
+	}
+
+	reached := graph.reach([]nodeId{start}, dir, depth, filter)
+	for _, r := range sortedByDepth(reached) {
+		if filter.shows(r.Type) {
+			fmt.Printf("%d\t%s\t%s\n", reached[r], r.Type, graph.label(r))
+		}
+	}
+	return nil
+}
+
+// DeadCode writes reports of unused tables, indexes, fields, public
+// procedures, methods, forms and reports to the output directory.  If
+// liveness is set, anything not reachable from an entry point is reported,
+// otherwise only things that are not referenced at all.
+func DeadCode(src GraphSource, outputDir string, format string, liveness bool) error {
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	return writeDeadCodeReports(graph.deadCode(liveness), outputDir, format)
+}
+
+// ExplainUsed prints why the named node is considered live, as the shortest
+// chains of references from an entry point to it, or why it is not.
+func ExplainUsed(src GraphSource, name string, nodeType string, maxPaths int) error {
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	target, err := graph.findNode(name, nodeType)
+	if err != nil {
+		return err
+	}
+	label := fmt.Sprintf("%s (%s)", graph.label(target), target.Type)
+
+	if reason, ok := graph.entryPoints[target]; ok {
+		fmt.Printf("%s is an entry point : %s\n", label, reason)
+		return nil
+	}
+
+	paths := graph.shortestPaths(graph.entryPointsSorted(), target, down, typeFilter{}, maxPaths)
+	if len(paths) != 0 {
+		fmt.Printf("%s is live, referenced from an entry point via :\n", label)
+		for _, path := range paths {
+			fmt.Printf("\t%s\n", graph.formatPath(path))
+			fmt.Printf("\t\t(%s is %s)\n", graph.label(path[0]), graph.entryPoints[path[0]])
+		}
+		return nil
+	}
+
+	referencedBy := graph.referencedBy()[target]
+	if len(referencedBy) == 0 {
+		fmt.Printf("%s is not live, and is not referenced by anything\n", label)
+		return nil
+	}
+
+	fmt.Printf("%s is not live, it is referenced only by code that is not live :\n", label)
+	for _, from := range referencedBy {
+		fmt.Printf("\t%s (%s)\n", graph.label(from), from.Type)
+	}
+	return nil
+}
+
+// Cycles prints the recursive reference cycles between nodes of the given
+// types, with the references involved in each.
+func Cycles(src GraphSource, types []string) error {
+	filter, err := newTypeFilter(types, nil)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	cycles := graph.cycles(func(id nodeId) bool { return filter.shows(id.Type) })
+
+	for i, cycle := range cycles {
+		fmt.Printf("cycle %d (%d nodes) :\n", i+1, len(cycle))
+		for _, edge := range graph.edgesWithin(cycle) {
+			fmt.Printf("\t%s\n", graph.formatPath(edge[:]))
+		}
+	}
+	fmt.Printf("%d cycles found\n", len(cycles))
+
+	return nil
+}
+
+// GraphMetrics prints a table of fan in, fan out, transitive reach,
+// betweenness and PageRank for nodes of the given types, sorted by the given
+// metric.
+func GraphMetrics(src GraphSource, types []string, sortBy string, limit int, samples int) error {
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	return graph.writeMetricsTable(types, sortBy, limit, samples)
+}
+
+// Clusters proposes subsystem boundaries by detecting communities of nodes of
+// the given types, and prints the clusters and the references between them.
+func Clusters(src GraphSource, types []string, resolution float64, listEdges bool) error {
+	include, err := nodeTypesFilter(types)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	graph.printClusters(include, resolution, listEdges)
+
+	return nil
+}
+
+// MigrationOrder prints nodes of the given types in dependency order, leaves
+// first, in layers.  Nodes that are part of a reference cycle are grouped
+// together, as they must be migrated together.
+func MigrationOrder(src GraphSource, types []string) error {
+	include, err := nodeTypesFilter(types)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	for i, layer := range graph.layers(include) {
+		size := 0
+		for _, component := range layer {
+			size += len(component)
+		}
+		fmt.Printf("layer %d : %d nodes\n", i, size)
+
+		for _, component := range layer {
+			if len(component) == 1 {
+				fmt.Printf("\t%s (%s)\n", graph.label(component[0]), component[0].Type)
+				continue
+			}
+			names := make([]string, 0, len(component))
+			for _, id := range component {
+				names = append(names, fmt.Sprintf("%s (%s)", graph.label(id), id.Type))
+			}
+			fmt.Printf("\tcycle : %s\n", strings.Join(names, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Dsm writes a dependency structure matrix, aggregated at the given level,
+// as CSV and as an HTML heatmap to the output directory.
+func Dsm(src GraphSource, level string, groupsJson string, outputDir string) error {
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	return graph.writeDsm(level, groupsJson, outputDir)
+}
+
+// Path prints the shortest reference paths from one named node to another.
+// If maxLength is non zero, all simple paths of up to that many references
+// are printed instead.
+func Path(src GraphSource, fromName string, fromType string, toName string, toType string, maxLength int, maxPaths int, exclude []string) error {
+	filter, err := newTypeFilter(nil, exclude)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	from, err := graph.findNode(fromName, fromType)
+	if err != nil {
+		return err
+	}
+	to, err := graph.findNode(toName, toType)
+	if err != nil {
+		return err
+	}
+
+	var paths [][]nodeId
+	if maxLength == 0 {
+		paths = graph.shortestPaths([]nodeId{from}, to, down, filter, maxPaths)
+	} else {
+		paths = graph.simplePaths(from, to, maxLength, filter, maxPaths)
+	}
+
+	if len(paths) == 0 {
+		fmt.Printf("no path found from %s (%s) to %s (%s)\n", graph.label(from), from.Type, graph.label(to), to.Type)
+		return nil
+	}
+	for _, path := range paths {
+		fmt.Println(graph.formatPath(path))
+	}
+	return nil
+}
+
+// CallTree prints an indented tree of everything the named node calls,
+// recursively, up to depth calls deep (zero for unlimited).  If types are
+// given, only calls to those node types are shown.
+func CallTree(src GraphSource, name string, nodeType string, depth int, types []string, repeat bool) error {
+	if len(types) == 0 {
+		types = callTypes
+	}
+	filter, err := onlyTypesFilter(types)
+	if err != nil {
+		return err
+	}
+
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	root, err := graph.findNode(name, nodeType)
+	if err != nil {
+		return err
+	}
+
+	graph.writeCallTree(os.Stdout, root, depth, filter, repeat)
+
+	return nil
+}
+
+// Impact prints every module that references the named table, field or
+// index, directly or transitively, grouped by module type and distance, with
+// usage according to ModuDet, if available.
+func Impact(src GraphSource, name string, nodeType string, depth int) error {
+	graph, err := buildGraph(src)
+	if err != nil {
+		return err
+	}
+
+	target, err := graph.findNode(name, nodeType)
+	if err != nil {
+		return err
+	}
+
+	impacted, err := graph.impact(target, depth)
+	if err != nil {
+		return err
+	}
 
-	graph.applySchema(schemaDumpJson)
+	graph.writeImpact(os.Stdout, target, impacted, src.hasUsage())
 
-	graph.applySpecial(specialJson)
+	return nil
+}
+
+// ChangeImpact prints the modules that changed between two git revisions of
+// the source root, every module that calls them (at the later revision),
+// directly or transitively, and the entry points affected.  The graphs are
+// built from the revisions, so the working tree is not touched.
+func ChangeImpact(src GraphSource, from string, to string) error {
+	files, err := changedFiles(src.SourceRoot, from, to)
+	if err != nil {
+		return err
+	}
+
+	after, err := buildGraphAt(src, to)
+	if err != nil {
+		return err
+	}
+
+	// Only the modules defined before are needed, to find those deleted
+	before, err := buildGraphAt(GraphSource{SourceRoot: src.SourceRoot}, from)
+	if err != nil {
+		return err
+	}
+
+	changed, deleted, unknown := changedNodes(before, after, files)
+
+	affected, entryPoints, err := after.changeImpact(append(slices.Clone(changed), deleted...))
+	if err != nil {
+		return err
+	}
+
+	after.writeChangeImpact(os.Stdout, before, changed, deleted, unknown, affected, entryPoints)
+
+	return nil
+}
+
+// GraphDiff builds the graph at two git revisions of the source root and
+// prints the nodes and references added and removed, and the nodes whose
+// used or missing status changed.  The working tree is not touched.
+func GraphDiff(src GraphSource, from string, to string) error {
+	before, err := buildGraphAt(src, from)
+	if err != nil {
+		return err
+	}
+
+	after, err := buildGraphAt(src, to)
+	if err != nil {
+		return err
+	}
+
+	diffGraphs(before, after).write(os.Stdout, before, after)
+
+	return nil
+}
+
+// ObservedCalls prints the calls between modules seen in production that
+// the static analysis misses, and the static calls never seen in production.
+// Observed calls are read with the module usage, from either CSV files or
+// the Postgres mirror.
+func ObservedCalls(src GraphSource) error {
+	if !src.hasUsage() {
+		return errors.New("observed calls are read with module usage, so either module CSV files or a usage DSN are required")
+	}
 
-	if err := walkSource(sourceRoot, graph); err != nil {
+	graph, err := buildGraph(src)
+	if err != nil {
 		return err
 	}
 
-	graph.applyModules(moduleCsv, modudetCsv)
+	graph.writeObservedReport(os.Stdout)
+
+	return nil
+}
+
+func buildGraph(src GraphSource) (*graph, error) {
+	since, err := parseSince(src.UsageSince, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Usage read from a DSN is by module name, so has no logic ids to check
+	if src.UsageDsn != "" && src.UnknownLogicIds != "" {
+		return nil, errors.New("unknown logic id handling only applies to usage read from CSV files, not a DSN")
+	}
+	unknownLogic := src.UnknownLogicIds
+	if unknownLogic == "" {
+		unknownLogic = unknownLogicWarn
+	}
+
+	graph := newGraph()
+
+	if err := graph.applySchema(src.SchemaDumpJson); err != nil {
+		return nil, err
+	}
+
+	// The default special JSON need not exist, but one given explicitly must
+	specialJson := src.SpecialJson
+	if specialJson == "" {
+		if _, err := os.Stat(defaultSpecialJson); err == nil {
+			specialJson = defaultSpecialJson
+		}
+	}
+	if err := graph.applySpecial(specialJson); err != nil {
+		return nil, err
+	}
+
+	if err := walkSource(src.SourceRoot, graph); err != nil {
+		return nil, err
+	}
+	if err := graph.relativeFiles(src.SourceRoot); err != nil {
+		return nil, err
+	}
+
+	if src.UsageDsn != "" {
+		if src.ModulesCsv != "" || src.ModudetCsv != "" {
+			return nil, errors.New("module usage must come from either CSV files or a DSN, not both")
+		}
+		if err := graph.applyModulesPostgres(src.UsageDsn, since); err != nil {
+			return nil, err
+		}
+	} else if err := graph.applyModules(src.ModulesCsv, src.ModudetCsv, since, unknownLogic); err != nil {
+		return nil, err
+	}
 
 	graph.makeIndexRefsAlsoTable()
 
-	return graph.writeGraph(graphOutput)
+	graph.markLive()
+
+	return graph, nil
 }
 
 func CalledMissingMethods(sourceRoot string) error {
@@ -0,0 +1,237 @@
+package graph
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// exportRevision writes the source root as it was at the given git revision
+// to a new temporary directory, without touching the working tree.  The
+// caller must remove the directory when done with it.
+func exportRevision(sourceRoot string, revision string) (string, error) {
+	// The source root may be a subdirectory of the repository
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel", "--show-prefix")
+	cmd.Dir = sourceRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("source root is not in a git repository : %w", err)
+	}
+	toplevel, prefix, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+
+	dir, err := os.MkdirTemp("", "billsourcery-")
+	if err != nil {
+		return "", err
+	}
+
+	var stderr bytes.Buffer
+	cmd = exec.Command("git", "archive", "--format=tar", revision+":"+prefix)
+	cmd.Dir = toplevel
+	cmd.Stderr = &stderr
+	archive, err := cmd.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	extractErr := extractTar(archive, dir)
+	if extractErr != nil {
+		// Drain the rest of the archive, otherwise git may block writing
+		// it, and never exit
+		io.Copy(io.Discard, archive)
+	}
+	if err := cmd.Wait(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to archive revision %s : %w : %s", revision, err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract revision %s : %w", revision, extractErr)
+	}
+
+	return dir, nil
+}
+
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, hdr.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("unexpected path in archive : %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// buildGraphAt builds the graph from the source root as it was at the given
+// git revision.
+func buildGraphAt(src GraphSource, revision string) (*graph, error) {
+	dir, err := exportRevision(src.SourceRoot, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	src.SourceRoot = dir
+	return buildGraph(src)
+}
+
+// graphDiff is the structural difference between two graphs.
+type graphDiff struct {
+	addedNodes      []nodeId
+	removedNodes    []nodeId
+	addedRefs       [][2]nodeId
+	removedRefs     [][2]nodeId
+	nowUsed         []nodeId
+	noLongerUsed    []nodeId
+	nowMissing      []nodeId
+	noLongerMissing []nodeId
+}
+
+// missing returns the nodes that are referenced but not defined.
+func (g *graph) missing() map[nodeId]struct{} {
+	missing := make(map[nodeId]struct{})
+	for _, n := range g.nodes {
+		for ref := range n.Refs {
+			if _, ok := g.nodes[ref]; !ok {
+				missing[ref] = struct{}{}
+			}
+		}
+	}
+	return missing
+}
+
+func diffGraphs(before *graph, after *graph) *graphDiff {
+	d := &graphDiff{}
+
+	// onlyIn returns the keys of a that are not in b, sorted
+	onlyIn := func(a map[nodeId]struct{}, b map[nodeId]struct{}) []nodeId {
+		var ids []nodeId
+		for id := range a {
+			if _, ok := b[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].id() < ids[j].id() })
+		return ids
+	}
+
+	nodesOf := func(g *graph) map[nodeId]struct{} {
+		ids := make(map[nodeId]struct{}, len(g.nodes))
+		for id := range g.nodes {
+			ids[id] = struct{}{}
+		}
+		return ids
+	}
+	beforeNodes, afterNodes := nodesOf(before), nodesOf(after)
+	d.addedNodes = onlyIn(afterNodes, beforeNodes)
+	d.removedNodes = onlyIn(beforeNodes, afterNodes)
+
+	// Used status only changes for nodes in both graphs, otherwise they are
+	// simply added or removed
+	usedOf := func(g *graph, others map[nodeId]struct{}) map[nodeId]struct{} {
+		used := make(map[nodeId]struct{})
+		for id := range g.used {
+			_, isNode := g.nodes[id]
+			_, isOther := others[id]
+			if isNode && isOther {
+				used[id] = struct{}{}
+			}
+		}
+		return used
+	}
+	d.nowUsed = onlyIn(usedOf(after, beforeNodes), usedOf(before, afterNodes))
+	d.noLongerUsed = onlyIn(usedOf(before, afterNodes), usedOf(after, beforeNodes))
+
+	beforeMissing, afterMissing := before.missing(), after.missing()
+	d.nowMissing = onlyIn(afterMissing, beforeMissing)
+	d.noLongerMissing = onlyIn(beforeMissing, afterMissing)
+
+	refsOf := func(g *graph) map[[2]nodeId]struct{} {
+		refs := make(map[[2]nodeId]struct{})
+		for _, n := range g.nodes {
+			for ref := range n.Refs {
+				refs[[2]nodeId{n.nodeId, ref}] = struct{}{}
+			}
+		}
+		return refs
+	}
+	refsOnlyIn := func(a map[[2]nodeId]struct{}, b map[[2]nodeId]struct{}) [][2]nodeId {
+		var refs [][2]nodeId
+		for ref := range a {
+			if _, ok := b[ref]; !ok {
+				refs = append(refs, ref)
+			}
+		}
+		sortRefs(refs)
+		return refs
+	}
+	beforeRefs, afterRefs := refsOf(before), refsOf(after)
+	d.addedRefs = refsOnlyIn(afterRefs, beforeRefs)
+	d.removedRefs = refsOnlyIn(beforeRefs, afterRefs)
+
+	return d
+}
+
+func (d *graphDiff) write(w io.Writer, before *graph, after *graph) {
+	writeNodes := func(title string, g *graph, ids []nodeId) {
+		fmt.Fprintf(w, "%s : %d\n", title, len(ids))
+		for _, id := range ids {
+			fmt.Fprintf(w, "\t%s\t%s\n", id.Type, g.label(id))
+		}
+	}
+	writeRefs := func(title string, g *graph, refs [][2]nodeId) {
+		fmt.Fprintf(w, "%s : %d\n", title, len(refs))
+		for _, ref := range refs {
+			fmt.Fprintf(w, "\t%s\n", g.formatPath(ref[:]))
+		}
+	}
+
+	writeNodes("nodes added", after, d.addedNodes)
+	writeNodes("nodes removed", before, d.removedNodes)
+	writeRefs("references added", after, d.addedRefs)
+	writeRefs("references removed", before, d.removedRefs)
+	writeNodes("now used", after, d.nowUsed)
+	writeNodes("no longer used", after, d.noLongerUsed)
+	writeNodes("now missing", after, d.nowMissing)
+	writeNodes("no longer missing", after, d.noLongerMissing)
+}
@@ -0,0 +1,109 @@
+package graph
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffGraphs(t *testing.T) {
+	assert := assert.New(t)
+
+	before := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("x")},
+	)
+	before.used[method("b")] = struct{}{}
+
+	after := testGraph(
+		[2]nodeId{method("a"), method("c")},
+		[2]nodeId{method("c"), method("y")},
+		[2]nodeId{method("x"), method("c")},
+	)
+	after.used[method("c")] = struct{}{}
+
+	d := diffGraphs(before, after)
+	assert.Equal([]nodeId{method("x")}, d.addedNodes)
+	assert.Equal([]nodeId{method("b")}, d.removedNodes)
+	assert.Equal([][2]nodeId{
+		{method("a"), method("c")},
+		{method("c"), method("y")},
+		{method("x"), method("c")},
+	}, d.addedRefs)
+	assert.Equal([][2]nodeId{
+		{method("a"), method("b")},
+		{method("b"), method("c")},
+		{method("c"), method("x")},
+	}, d.removedRefs)
+	assert.Equal([]nodeId{method("c")}, d.nowUsed)
+	assert.Empty(d.noLongerUsed)
+	assert.Equal([]nodeId{method("y")}, d.nowMissing)
+	assert.Equal([]nodeId{method("x")}, d.noLongerMissing)
+}
+
+func TestExportRevision(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := testGitRepo(t)
+	root := filepath.Join(repo, "src")
+
+	writeModule(t, root, "Methods/a.jc@.txt", "A.jcl", "\r\n")
+	writeModule(t, repo, "Other/b.jc@.txt", "B.jcl", "\r\n")
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-qm", "one")
+	runGit(t, repo, "tag", "one")
+
+	writeModule(t, root, "Methods/a.jc@.txt", "A.jcl", "Execute Method \"C.jcl\"\r\n")
+	runGit(t, repo, "commit", "-qam", "two")
+
+	dir, err := exportRevision(root, "one")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// Only the source root, as it was at the revision
+	a, err := os.ReadFile(filepath.Join(dir, "Methods", "a.jc@.txt"))
+	assert.NoError(err)
+	assert.Equal("FIL,130,A.jcl,x\nTXT,132,2,x\n\r\nXTX,\n", string(a))
+	assert.NoFileExists(filepath.Join(dir, "Other", "b.jc@.txt"))
+	assert.NoFileExists(filepath.Join(dir, "src", "Methods", "a.jc@.txt"))
+
+	_, err = exportRevision(root, "nosuchrevision")
+	assert.ErrorContains(err, "failed to archive revision nosuchrevision")
+
+	_, err = exportRevision(t.TempDir(), "one")
+	assert.ErrorContains(err, "not in a git repository")
+}
+
+func TestExtractTar(t *testing.T) {
+	assert := assert.New(t)
+
+	archive := func(names ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(name)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+
+	dir := t.TempDir()
+	assert.NoError(extractTar(archive("Methods/a.jc@.txt", "Forms/b.fr@.txt"), dir))
+	b, err := os.ReadFile(filepath.Join(dir, "Forms", "b.fr@.txt"))
+	assert.NoError(err)
+	assert.Equal("Forms/b.fr@.txt", string(b))
+
+	assert.ErrorContains(extractTar(archive("../escaped.txt"), t.TempDir()), "unexpected path in archive")
+}
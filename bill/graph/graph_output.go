@@ -3,6 +3,8 @@ package graph
 import (
 	"fmt"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/iancoleman/strcase"
@@ -11,46 +13,232 @@ import (
 type graphOutput interface {
 	Start() error
 	End() error
-	AddNode(id string, name string, tags []string) error
-	AddReference(from_id string, to_id string) error
+	AddNode(id string, name string, tags []string, props properties) error
+	AddReference(from_id string, to_id string, kind refKind, props properties) error
 }
 
-type DotGraphOutput struct{}
+// refKind is the kind of a reference, which depends on what is referenced.
+type refKind string
+
+const (
+	rkCall     refKind = "call"
+	rkTable    refKind = "table"
+	rkField    refKind = "field"
+	rkIndex    refKind = "index"
+	rkWorkArea refKind = "work_area"
+	rkMember   refKind = "member"   // a field or index belonging to a table
+	rkObserved refKind = "observed" // a call seen in production (ModuDet)
+)
+
+func kindOf(from nodeId, to nodeId) refKind {
+	switch {
+	case (from.Type == ntField || from.Type == ntIndex) && to.Type == ntTable:
+		return rkMember
+	case to.Type == ntTable:
+		return rkTable
+	case to.Type == ntField:
+		return rkField
+	case to.Type == ntIndex:
+		return rkIndex
+	case to.Type == ntWorkArea:
+		return rkWorkArea
+	default:
+		return rkCall
+	}
+}
+
+// properties are additional named values for a node, e.g. metrics. Values
+// are ints, float64s or strings.
+type properties map[string]any
+
+func (p properties) keysSorted() []string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeColour returns the fill colour used for a node with the given tags, or
+// the empty string if the node should not be coloured.
+func nodeColour(tags []string) string {
+	if slices.Contains(tags, "form") {
+		return "lightgreen"
+	} else if slices.Contains(tags, "report") {
+		return "orange"
+	} else if slices.Contains(tags, "public_procedure") {
+		return "yellow"
+	} else if slices.Contains(tags, "method") {
+		if slices.Contains(tags, "missing") {
+			return "red"
+		}
+		return "lightblue"
+	}
+	return ""
+}
+
+// multipleCount returns the count of merged references, if there is more
+// than one.
+func multipleCount(props properties) (int, bool) {
+	count, ok := props["count"].(int)
+	return count, ok && count > 1
+}
+
+// DotGraphOutput writes a Graphviz dot digraph.  By default this is a flat
+// graph with nodes coloured by type.  Optionally, nodes can be clustered, and
+// the graph styled for presentation with distinct edge styles per reference
+// kind, a legend, and rank hints.
+type DotGraphOutput struct {
+	// Cluster, if set, groups nodes into subgraphs by their "type" or by
+	// their "group" property
+	Cluster string
+	// Styled enables edge styles per reference kind, a legend and rank hints
+	Styled bool
+
+	clusters    map[string][]string
+	unclustered []string
+	ranks       map[string][]string
+	refs        []string
+}
+
+// dotEdgeStyles are the edge attributes for each kind of reference, when
+// styled.
+var dotEdgeStyles = map[refKind]string{
+	rkCall:     `style="solid" color="black"`,
+	rkTable:    `style="bold" color="blue"`,
+	rkField:    `style="dotted" color="grey40"`,
+	rkIndex:    `style="dashed" color="grey40"`,
+	rkWorkArea: `style="dotted" color="purple"`,
+	rkMember:   `style="dashed" color="grey70" arrowhead="none"`,
+	rkObserved: `style="dashed" color="red"`,
+}
 
 func (o *DotGraphOutput) Start() error {
+	o.clusters = make(map[string][]string)
+	o.unclustered = nil
+	o.ranks = make(map[string][]string)
+	o.refs = nil
+
 	fmt.Println("digraph calls {")
+	if o.Styled {
+		fmt.Println("\trankdir=LR")
+		fmt.Println("\tnewrank=true")
+		fmt.Println("\tnode [shape=box fontname=\"Helvetica\"]")
+	}
 	return nil
 }
 
 func (o *DotGraphOutput) End() error {
+	names := make([]string, 0, len(o.clusters))
+	for name := range o.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		fmt.Printf("\tsubgraph cluster_%d {\n", i)
+		fmt.Printf("\t\tlabel=\"%s\"\n", name)
+		for _, line := range o.clusters[name] {
+			fmt.Printf("\t%s\n", line)
+		}
+		fmt.Println("\t}")
+	}
+
+	for _, line := range o.unclustered {
+		fmt.Println(line)
+	}
+
+	for _, ref := range o.refs {
+		fmt.Println(ref)
+	}
+
+	if o.Styled {
+		// Forms are where users start, and tables are where everything ends
+		if ids := o.ranks[ntForm.String()]; len(ids) != 0 {
+			fmt.Printf("\t{rank=min; %s}\n", strings.Join(ids, "; "))
+		}
+		if ids := o.ranks[ntTable.String()]; len(ids) != 0 {
+			fmt.Printf("\t{rank=max; %s}\n", strings.Join(ids, "; "))
+		}
+		o.writeLegend()
+	}
+
 	fmt.Println("}")
 	return nil
 }
 
-func (o *DotGraphOutput) AddNode(id string, name string, tags []string) error {
-	colour := ""
+func (o *DotGraphOutput) writeLegend() {
+	fmt.Println("\tsubgraph cluster_legend {")
+	fmt.Println("\t\tlabel=\"Legend\"")
+	for i, tags := range [][]string{
+		{"form"},
+		{"report"},
+		{"public_procedure"},
+		{"method"},
+		{"method", "missing"},
+		{"table"},
+	} {
+		fmt.Printf("\t\tlegend_node_%d [label=\"%s\" style=\"filled\" fillcolor=\"%s\"]\n", i, strings.Join(tags, " "), nodeColour(tags))
+	}
+	for i, kind := range []refKind{rkCall, rkTable, rkField, rkIndex, rkWorkArea, rkMember, rkObserved} {
+		fmt.Printf("\t\tlegend_from_%d [label=\"\" shape=point]\n", i)
+		fmt.Printf("\t\tlegend_to_%d [label=\"\" shape=point]\n", i)
+		fmt.Printf("\t\tlegend_from_%d -> legend_to_%d [label=\"%s\" %s]\n", i, i, kind, dotEdgeStyles[kind])
+	}
+	fmt.Println("\t}")
+}
 
-	if slices.Contains(tags, "form") {
-		colour = "lightgreen"
-	} else if slices.Contains(tags, "report") {
-		colour = "orange"
-	} else if slices.Contains(tags, "public_procedure") {
-		colour = "yellow"
-	} else if slices.Contains(tags, "method") {
-		if slices.Contains(tags, "missing") {
-			colour = "red"
-		} else {
-			colour = "lightblue"
+func (o *DotGraphOutput) AddNode(id string, name string, tags []string, props properties) error {
+	line := fmt.Sprintf("\t%s [label=\"%s\" style=\"filled\" fillcolor=\"%s\"]", id, name, nodeColour(tags))
+
+	if len(tags) != 0 {
+		o.ranks[tags[0]] = append(o.ranks[tags[0]], id)
+	}
+
+	var cluster string
+	switch o.Cluster {
+	case "":
+		fmt.Println(line)
+		return nil
+	case "type":
+		cluster = tags[0]
+	default:
+		group, ok := props["group"]
+		if !ok {
+			// Not in any group, so not in a cluster
+			o.unclustered = append(o.unclustered, line)
+			return nil
 		}
+		cluster = fmt.Sprint(group)
 	}
 
-	fmt.Printf("\t%s [label=\"%s\" style=\"filled\" fillcolor=\"%s\"]\n", id, name, colour)
+	o.clusters[cluster] = append(o.clusters[cluster], line)
 
 	return nil
 }
 
-func (o *DotGraphOutput) AddReference(from string, to string) error {
-	fmt.Printf("\t%s -> %s\n", from, to)
+func (o *DotGraphOutput) AddReference(from string, to string, kind refKind, props properties) error {
+	var attrs []string
+	if count, ok := multipleCount(props); ok {
+		attrs = append(attrs, fmt.Sprintf("label=\"%v\"", count))
+	}
+	// Observed calls are always distinguished from the static references
+	if o.Styled || kind == rkObserved {
+		attrs = append(attrs, dotEdgeStyles[kind])
+	}
+
+	line := fmt.Sprintf("\t%s -> %s", from, to)
+	if len(attrs) != 0 {
+		line += " [" + strings.Join(attrs, " ") + "]"
+	}
+
+	// When clustering, nodes must be declared (in their clusters) before
+	// any references to them
+	if o.Cluster != "" {
+		o.refs = append(o.refs, line)
+		return nil
+	}
+	fmt.Println(line)
 	return nil
 }
 
@@ -64,7 +252,7 @@ func (o *NeoGraphOutput) End() error {
 	return nil
 }
 
-func (o NeoGraphOutput) AddNode(id string, name string, tags []string) error {
+func (o NeoGraphOutput) AddNode(id string, name string, tags []string, props properties) error {
 	fmt.Printf("MERGE (n:Node {id:\"%s\"}) SET n.name=\"%s\" ", id, name)
 
 	var tagString strings.Builder
@@ -76,12 +264,139 @@ func (o NeoGraphOutput) AddNode(id string, name string, tags []string) error {
 
 	}
 
-	fmt.Printf("SET n %s;\n", tagString.String())
+	fmt.Printf("SET n %s", tagString.String())
+
+	for _, k := range props.keysSorted() {
+		fmt.Printf(" SET n.%s=%s", k, neoValue(props[k]))
+	}
+
+	fmt.Println(";")
+
+	return nil
+}
+
+func neoValue(v any) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprintf("\"%v\"", v)
+	}
+}
+
+func (o *NeoGraphOutput) AddReference(from string, to string, kind refKind, props properties) error {
+	relationship := "references"
+	if kind == rkObserved {
+		relationship = "observed_calls"
+	}
+
+	if len(props) == 0 {
+		fmt.Printf("MERGE (f:Node {id: \"%s\"}) MERGE (t:Node {id: \"%s\"}) MERGE (f)-[:%s]->(t);\n", from, to, relationship)
+		return nil
+	}
+
+	fmt.Printf("MERGE (f:Node {id: \"%s\"}) MERGE (t:Node {id: \"%s\"}) MERGE (f)-[r:%s]->(t)", from, to, relationship)
+	for _, k := range props.keysSorted() {
+		fmt.Printf(" SET r.%s=%s", k, neoValue(props[k]))
+	}
+	fmt.Println(";")
+	return nil
+}
+
+// MermaidGraphOutput writes a Mermaid flowchart, suitable for pasting small
+// graphs into Markdown documents and GitHub issues.
+type MermaidGraphOutput struct {
+	styled map[string]struct{}
+}
+
+func (o *MermaidGraphOutput) Start() error {
+	o.styled = make(map[string]struct{})
+	fmt.Println("flowchart LR")
+	return nil
+}
+
+func (o *MermaidGraphOutput) End() error {
+	return nil
+}
+
+func (o *MermaidGraphOutput) AddNode(id string, name string, tags []string, props properties) error {
+	label := strings.ReplaceAll(name, "\"", "#quot;")
+
+	colour := nodeColour(tags)
+	if colour == "" {
+		fmt.Printf("\t%s[\"%s\"]\n", id, label)
+		return nil
+	}
+
+	// Define a class per colour the first time it is seen
+	if _, ok := o.styled[colour]; !ok {
+		fmt.Printf("\tclassDef %s fill:%s\n", colour, colour)
+		o.styled[colour] = struct{}{}
+	}
+	fmt.Printf("\t%s[\"%s\"]:::%s\n", id, label, colour)
+
+	return nil
+}
 
+func (o *MermaidGraphOutput) AddReference(from string, to string, kind refKind, props properties) error {
+	arrow := "-->"
+	if kind == rkObserved {
+		arrow = "-.->"
+	}
+	if count, ok := multipleCount(props); ok {
+		fmt.Printf("\t%s %s|%v| %s\n", from, arrow, count, to)
+		return nil
+	}
+	fmt.Printf("\t%s %s %s\n", from, arrow, to)
 	return nil
 }
 
-func (o *NeoGraphOutput) AddReference(from string, to string) error {
-	fmt.Printf("MERGE (f:Node {id: \"%s\"}) MERGE (t:Node {id: \"%s\"}) MERGE (f)-[:references]->(t);\n", from, to)
+// PlantUMLGraphOutput writes a PlantUML diagram, suitable for pasting small
+// graphs into design documents.  References are held back until the end,
+// because PlantUML will not allow a node to be declared after it has been
+// implicitly created by a reference (as happens with missing nodes).
+type PlantUMLGraphOutput struct {
+	refs []string
+}
+
+func (o *PlantUMLGraphOutput) Start() error {
+	o.refs = nil
+	fmt.Println("@startuml")
+	return nil
+}
+
+func (o *PlantUMLGraphOutput) End() error {
+	for _, ref := range o.refs {
+		fmt.Println(ref)
+	}
+	fmt.Println("@enduml")
+	return nil
+}
+
+func (o *PlantUMLGraphOutput) AddNode(id string, name string, tags []string, props properties) error {
+	label := strings.ReplaceAll(name, "\"", "'")
+
+	colour := nodeColour(tags)
+	if colour == "" {
+		fmt.Printf("rectangle \"%s\" as %s\n", label, id)
+		return nil
+	}
+	fmt.Printf("rectangle \"%s\" as %s #%s\n", label, id, colour)
+
+	return nil
+}
+
+func (o *PlantUMLGraphOutput) AddReference(from string, to string, kind refKind, props properties) error {
+	arrow := "-->"
+	if kind == rkObserved {
+		arrow = "..>"
+	}
+	if count, ok := multipleCount(props); ok {
+		o.refs = append(o.refs, fmt.Sprintf("%s %s %s : %v", from, arrow, to, count))
+		return nil
+	}
+	o.refs = append(o.refs, fmt.Sprintf("%s %s %s", from, arrow, to))
 	return nil
 }
@@ -0,0 +1,213 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureStdout returns what fn writes to stdout, as the graph outputs write
+// there directly.
+func captureStdout(t *testing.T, fn func() error) string {
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	err = fn()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func outputTestGraph() *graph {
+	g := testGraph(
+		[2]nodeId{newNodeId("custform", ntForm), method("a")},
+		[2]nodeId{method("a"), newNodeId("ginv", ntTable)},
+		[2]nodeId{method("a"), method("gone")},
+	)
+	g.setProperties(method("a"), properties{"group": "billing"})
+	g.setProperties(newNodeId("ginv", ntTable), properties{"group": "billing"})
+	return g
+}
+
+func TestDotGraphOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	g := outputTestGraph()
+	g.observed[[2]nodeId{method("a"), newNodeId("custform", ntForm)}] = 3
+
+	// Observed calls are styled even when the rest isn't
+	assert.Equal(`digraph calls {
+	a_a_method [label="a" style="filled" fillcolor="lightblue"]
+	a_custform_form [label="custform" style="filled" fillcolor="lightgreen"]
+	a_ginv_table [label="ginv" style="filled" fillcolor=""]
+	a_a_method -> a_ginv_table
+	a_a_method -> a_gone_method
+	a_custform_form -> a_a_method
+	a_a_method -> a_custform_form [label="3" style="dashed" color="red"]
+	a_gone_method [label="gone" style="filled" fillcolor="red"]
+}
+`, captureStdout(t, func() error { return g.writeGraph(&DotGraphOutput{}) }))
+}
+
+func TestDotGraphOutputClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	// Nodes are declared in their clusters, including missing nodes, before
+	// the references
+	assert.Equal(`digraph calls {
+	subgraph cluster_0 {
+		label="form"
+		a_custform_form [label="custform" style="filled" fillcolor="lightgreen"]
+	}
+	subgraph cluster_1 {
+		label="method"
+		a_a_method [label="a" style="filled" fillcolor="lightblue"]
+		a_gone_method [label="gone" style="filled" fillcolor="red"]
+	}
+	subgraph cluster_2 {
+		label="table"
+		a_ginv_table [label="ginv" style="filled" fillcolor=""]
+	}
+	a_a_method -> a_ginv_table
+	a_a_method -> a_gone_method
+	a_custform_form -> a_a_method
+}
+`, captureStdout(t, func() error { return outputTestGraph().writeGraph(&DotGraphOutput{Cluster: "type"}) }))
+
+	// Nodes not in any group are left out of the clusters
+	out := captureStdout(t, func() error { return outputTestGraph().writeGraph(&DotGraphOutput{Cluster: "group"}) })
+	assert.Contains(out, `	subgraph cluster_0 {
+		label="billing"
+		a_a_method [label="a" style="filled" fillcolor="lightblue"]
+		a_ginv_table [label="ginv" style="filled" fillcolor=""]
+	}
+	a_custform_form [label="custform" style="filled" fillcolor="lightgreen"]
+	a_gone_method [label="gone" style="filled" fillcolor="red"]
+	a_a_method -> a_ginv_table
+`)
+}
+
+func TestDotGraphOutputStyled(t *testing.T) {
+	assert := assert.New(t)
+
+	out := captureStdout(t, func() error { return outputTestGraph().writeGraph(&DotGraphOutput{Styled: true}) })
+
+	assert.Contains(out, "digraph calls {\n\trankdir=LR\n")
+	assert.Contains(out, `	a_a_method -> a_ginv_table [style="bold" color="blue"]`)
+	assert.Contains(out, `	a_custform_form -> a_a_method [style="solid" color="black"]`)
+	assert.Contains(out, "\t{rank=min; a_custform_form}\n\t{rank=max; a_ginv_table}\n")
+
+	// The legend has every node colour and edge style
+	assert.Contains(out, "\tsubgraph cluster_legend {\n")
+	assert.Contains(out, `legend_node_4 [label="method missing" style="filled" fillcolor="red"]`)
+	for kind, style := range dotEdgeStyles {
+		assert.Contains(out, fmt.Sprintf(`[label="%s" %s]`, kind, style))
+	}
+}
+
+func TestGraphOptionsValidated(t *testing.T) {
+	assert := assert.New(t)
+
+	// Options are checked before the (missing) source is read
+	src := GraphSource{SourceRoot: filepath.Join(t.TempDir(), "missing")}
+
+	assert.ErrorContains(Graph(src, "neo", GraphOptions{Cluster: "type"}), "only supported by the dot output")
+	assert.ErrorContains(Graph(src, "mermaid", GraphOptions{Styled: true}), "only supported by the dot output")
+	assert.ErrorContains(Graph(src, "dot", GraphOptions{Cluster: "nonsense"}), "unknown clustering")
+	assert.ErrorContains(Graph(src, "dot", GraphOptions{Cluster: "group"}), "groups JSON file is required")
+}
+
+// escapingTestGraph has a label with quotes and brackets, two nodes of the
+// same colour, and a missing node, which is output after the references.
+func escapingTestGraph() *graph {
+	g := testGraph(
+		[2]nodeId{newNodeId("custform", ntForm), method("a")},
+		[2]nodeId{newNodeId("custform", ntForm), method("b")},
+		[2]nodeId{method("a"), method("gone")},
+		[2]nodeId{method("b"), newNodeId("ginv", ntTable)},
+	)
+	g.nodes[method("a")].Label = `say "hi" [x]`
+	g.observed[[2]nodeId{method("b"), newNodeId("custform", ntForm)}] = 3
+	return g
+}
+
+func TestMermaidGraphOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	// Quotes are entities within the quoted labels, which may then contain
+	// brackets, and each colour's class is defined once, before first use
+	assert.Equal(`flowchart LR
+	classDef lightblue fill:lightblue
+	a_b_method["b"]:::lightblue
+	classDef lightgreen fill:lightgreen
+	a_custform_form["custform"]:::lightgreen
+	a_ginv_table["ginv"]
+	a_a_method["say #quot;hi#quot; [x]"]:::lightblue
+	a_b_method --> a_ginv_table
+	a_custform_form --> a_a_method
+	a_custform_form --> a_b_method
+	a_a_method --> a_gone_method
+	a_b_method -.->|3| a_custform_form
+	classDef red fill:red
+	a_gone_method["gone"]:::red
+`, captureStdout(t, func() error { return escapingTestGraph().writeGraph(&MermaidGraphOutput{}) }))
+}
+
+func TestPlantUMLGraphOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	// References are deferred until after every node, including the missing
+	// node declared after the references to it
+	assert.Equal(`@startuml
+rectangle "b" as a_b_method #lightblue
+rectangle "custform" as a_custform_form #lightgreen
+rectangle "ginv" as a_ginv_table
+rectangle "say 'hi' [x]" as a_a_method #lightblue
+rectangle "gone" as a_gone_method #red
+a_b_method --> a_ginv_table
+a_custform_form --> a_a_method
+a_custform_form --> a_b_method
+a_a_method --> a_gone_method
+a_b_method ..> a_custform_form : 3
+@enduml
+`, captureStdout(t, func() error { return escapingTestGraph().writeGraph(&PlantUMLGraphOutput{}) }))
+
+	// A reference to a node that is never declared is still written, at
+	// the end, for PlantUML to create the node implicitly
+	assert.Equal(`@startuml
+rectangle "a" as a
+a --> never : 2
+@enduml
+`, captureStdout(t, func() error {
+		o := &PlantUMLGraphOutput{}
+		if err := o.Start(); err != nil {
+			return err
+		}
+		if err := o.AddReference("a", "never", rkCall, properties{"count": 2}); err != nil {
+			return err
+		}
+		if err := o.AddNode("a", "a", nil, nil); err != nil {
+			return err
+		}
+		return o.End()
+	}))
+}
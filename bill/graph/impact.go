@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// moduleTypes are the node types of modules, that is, the things that call
+// one another and reference tables.
+var moduleTypes = []string{
+	ntExport.String(),
+	ntForm.String(),
+	ntImport.String(),
+	ntMethod.String(),
+	ntProcess.String(),
+	ntPubProc.String(),
+	ntQuery.String(),
+	ntReport.String(),
+}
+
+// impact returns every module that references the target table, field or
+// index, directly or via callers of callers, with its distance from the
+// target.  A module referencing a field or index of a target table counts as
+// referencing the table directly.  A maxDepth of zero means unlimited.
+func (g *graph) impact(target nodeId, maxDepth int) (map[nodeId]int, error) {
+	switch target.Type {
+	case ntTable, ntField, ntIndex:
+	default:
+		return nil, fmt.Errorf("impact analysis is for tables, fields and indexes, not %s", target.Type)
+	}
+
+	start := []nodeId{target}
+	if target.Type == ntTable {
+		for _, member := range g.referencedBy()[target] {
+			if member.Type == ntField || member.Type == ntIndex {
+				start = append(start, member)
+			}
+		}
+	}
+
+	modules, err := onlyTypesFilter(moduleTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.reach(start, up, maxDepth, modules), nil
+}
+
+// writeImpact writes the impacted modules grouped by type, and then by
+// distance from the target, with whether each is used in production.  Usage
+// is unknown for the types of module that ModuDet does not record, such as
+// public procedures.
+func (g *graph) writeImpact(w io.Writer, target nodeId, impacted map[nodeId]int, withUsage bool) {
+	byType := make(map[nodeType][]nodeId)
+	for _, id := range sortedByDepth(impacted) {
+		byType[id.Type] = append(byType[id.Type], id)
+	}
+
+	types := make([]nodeType, 0, len(byType))
+	for nt := range byType {
+		types = append(types, nt)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	fmt.Fprintf(w, "%s (%s) is referenced by %d modules\n", g.label(target), target.Type, len(impacted))
+	for _, nt := range types {
+		fmt.Fprintf(w, "%s : %d\n", nt, len(byType[nt]))
+		for _, id := range byType[nt] {
+			usage := "unknown"
+			if _, recorded := moduleExtensions[id.Type]; withUsage && recorded {
+				usage = "not used"
+				if g.entryPoints[id] == reasonModuDet {
+					usage = fmt.Sprintf("used (%v calls, last %v)", g.props[id]["calls"], g.props[id]["last_used"])
+				}
+			}
+			fmt.Fprintf(w, "\t%d\t%s\t%s\n", impacted[id], g.label(id), usage)
+		}
+	}
+}
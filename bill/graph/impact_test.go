@@ -0,0 +1,201 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpact(t *testing.T) {
+	assert := assert.New(t)
+
+	table := newNodeId("t", ntTable)
+	field := newNodeId("t.f", ntField)
+
+	g := testGraph(
+		[2]nodeId{field, table},
+		[2]nodeId{method("a"), field},
+		[2]nodeId{method("b"), method("a")},
+		[2]nodeId{method("c"), method("b")},
+		[2]nodeId{newNodeId("frm", ntForm), table},
+		[2]nodeId{method("d"), method("x")},
+	)
+
+	impacted, err := g.impact(table, 0)
+	assert.NoError(err)
+	assert.Equal(map[nodeId]int{
+		method("a"):              1,
+		newNodeId("frm", ntForm): 1,
+		method("b"):              2,
+		method("c"):              3,
+	}, impacted)
+
+	impacted, err = g.impact(field, 2)
+	assert.NoError(err)
+	assert.Equal(map[nodeId]int{
+		method("a"): 1,
+		method("b"): 2,
+	}, impacted)
+
+	_, err = g.impact(method("a"), 0)
+	assert.Error(err)
+}
+
+func TestWriteImpact(t *testing.T) {
+	assert := assert.New(t)
+
+	table := newNodeId("t", ntTable)
+	pp := newNodeId("calcpp", ntPubProc)
+	g := testGraph(
+		[2]nodeId{method("a"), table},
+		[2]nodeId{method("b"), table},
+		[2]nodeId{pp, method("a")},
+	)
+	g.applyUsageByName(map[string]*usage{
+		"A.jcl": {calls: 3, first: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), last: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	impacted, err := g.impact(table, 0)
+	assert.NoError(err)
+
+	// ModuDet does not record public procedures, so their usage is unknown
+	var sb strings.Builder
+	g.writeImpact(&sb, table, impacted, true)
+	assert.Equal(`t (table) is referenced by 3 modules
+method : 2
+	1	a	used (3 calls, last 2024-02-01)
+	1	b	not used
+public_procedure : 1
+	2	calcpp	unknown
+`, sb.String())
+
+	sb.Reset()
+	g.writeImpact(&sb, table, impacted, false)
+	assert.Contains(sb.String(), "\t1\tb\tunknown\n")
+}
+
+func TestChangeImpact(t *testing.T) {
+	assert := assert.New(t)
+
+	before := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("gone"), method("b")},
+		[2]nodeId{method("e"), method("gone")},
+	)
+	before.files["Methods/a.jc@.txt"] = []nodeId{method("a")}
+	before.files["Methods/gone.jc@.txt"] = []nodeId{method("gone")}
+
+	after := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("a")},
+		[2]nodeId{newNodeId("frm", ntForm), method("a")},
+		[2]nodeId{method("c"), method("d")},
+		[2]nodeId{method("e"), method("gone")},
+	)
+	after.entryPoints[newNodeId("frm", ntForm)] = "menu form (special JSON)"
+	after.entryPoints[method("c")] = reasonModuDet
+	after.files["Methods/a.jc@.txt"] = []nodeId{method("a")}
+
+	changed, deleted, unknown := changedNodes(before, after, []string{"Methods/a.jc@.txt", "Methods/gone.jc@.txt", "notes.txt"})
+	assert.Equal([]nodeId{method("a")}, changed)
+	assert.Equal([]nodeId{method("gone")}, deleted)
+	assert.Equal([]string{"notes.txt"}, unknown)
+
+	// Callers of deleted modules are affected too
+	affected, entryPoints, err := after.changeImpact(append(changed, deleted...))
+	assert.NoError(err)
+	assert.Equal(map[nodeId]int{
+		method("b"):              1,
+		method("e"):              1,
+		newNodeId("frm", ntForm): 1,
+	}, affected)
+	assert.Equal([]nodeId{newNodeId("frm", ntForm)}, entryPoints)
+}
+
+// testGitRepo creates a git repository for tests, skipping the test if git
+// is not available.
+func testGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v : %v\n%s", args, err, out)
+	}
+}
+
+// writeModule writes a source export file for a module, with the given text,
+// under the source root.
+func writeModule(t *testing.T, root string, path string, fullName string, text string) {
+	path = filepath.Join(root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("FIL,130,%s,x\nTXT,132,%d,x\n%sXTX,\n", fullName, len(text), text)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangeImpactFromGit(t *testing.T) {
+	assert := assert.New(t)
+
+	repo := testGitRepo(t)
+	// The source root need not be the top of the repository
+	root := filepath.Join(repo, "src")
+
+	writeModule(t, root, "Methods/a.jc@.txt", "A.jcl", "\r\n")
+	writeModule(t, root, "Methods/gone.jc@.txt", "Gone.jcl", "\r\n")
+	writeModule(t, root, "Methods/caller.jc@.txt", "Caller.jcl", "Execute Method \"Gone.jcl\"\r\n")
+	writeModule(t, root, "Forms/façade form.fr@.txt", "Facade.frm", "Execute Method \"A.jcl\"\r\n")
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-qm", "one")
+	runGit(t, repo, "tag", "one")
+
+	writeModule(t, root, "Methods/a.jc@.txt", "A.jcl", "Execute Method \"B.jcl\"\r\n")
+	writeModule(t, root, "Forms/façade form.fr@.txt", "Facade.frm", "Execute Method \"A.jcl\"\r\nExecute Method \"B.jcl\"\r\n")
+	if err := os.Remove(filepath.Join(root, "Methods", "gone.jc@.txt")); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, repo, "add", "-A")
+	runGit(t, repo, "commit", "-qm", "two")
+	runGit(t, repo, "tag", "two")
+
+	// The working tree is not what is being compared
+	writeModule(t, root, "Methods/a.jc@.txt", "Uncommitted.jcl", "\r\n")
+
+	files, err := changedFiles(root, "one", "two")
+	assert.NoError(err)
+	assert.Equal([]string{
+		filepath.Join("Forms", "façade form.fr@.txt"),
+		filepath.Join("Methods", "a.jc@.txt"),
+		filepath.Join("Methods", "gone.jc@.txt"),
+	}, files)
+
+	before, err := buildGraphAt(GraphSource{SourceRoot: root}, "one")
+	assert.NoError(err)
+	after, err := buildGraphAt(GraphSource{SourceRoot: root}, "two")
+	assert.NoError(err)
+
+	changed, deleted, unknown := changedNodes(before, after, files)
+	assert.Equal([]nodeId{method("a"), newNodeId("facade", ntForm)}, changed)
+	assert.Equal([]nodeId{method("gone")}, deleted)
+	assert.Empty(unknown)
+
+	affected, _, err := after.changeImpact(append(changed, deleted...))
+	assert.NoError(err)
+	assert.Equal(map[nodeId]int{method("caller"): 1}, affected)
+}
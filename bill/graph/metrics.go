@@ -0,0 +1,318 @@
+package graph
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// moduleAndTableTypes are the node types considered by default when
+// computing metrics.  Fields, indexes and work areas are excluded because
+// they massively increase the size of the graph without telling us much
+// about the structure of the application.
+var moduleAndTableTypes = []nodeType{
+	ntExport,
+	ntForm,
+	ntImport,
+	ntMethod,
+	ntProcess,
+	ntPubProc,
+	ntQuery,
+	ntReport,
+	ntTable,
+}
+
+// adjacency is a compact, index based, representation of (part of) the
+// graph for algorithms that need to visit every node many times.
+type adjacency struct {
+	ids   []nodeId
+	index map[nodeId]int
+	out   [][]int
+	in    [][]int
+}
+
+// newAdjacency builds an adjacency of the nodes in the graph for which
+// include returns true, and the references between them.
+func (g *graph) newAdjacency(include func(nodeId) bool) *adjacency {
+	a := &adjacency{index: make(map[nodeId]int)}
+	for _, n := range g.nodesSorted() {
+		if include(n.nodeId) {
+			a.index[n.nodeId] = len(a.ids)
+			a.ids = append(a.ids, n.nodeId)
+		}
+	}
+	a.out = make([][]int, len(a.ids))
+	a.in = make([][]int, len(a.ids))
+	for i, id := range a.ids {
+		for _, ref := range g.nodes[id].refsSorted() {
+			j, ok := a.index[ref]
+			if !ok {
+				continue
+			}
+			a.out[i] = append(a.out[i], j)
+			a.in[j] = append(a.in[j], i)
+		}
+	}
+	return a
+}
+
+// nodeMetrics are the structural metrics for a single node.
+type nodeMetrics struct {
+	FanIn       int
+	FanOut      int
+	Reach       int
+	Betweenness float64
+	PageRank    float64
+}
+
+func (m nodeMetrics) properties() properties {
+	return properties{
+		"fan_in":      m.FanIn,
+		"fan_out":     m.FanOut,
+		"reach":       m.Reach,
+		"betweenness": m.Betweenness,
+		"page_rank":   m.PageRank,
+	}
+}
+
+// metrics computes the metrics for every node in the adjacency.  Betweenness
+// is computed exactly if samples is zero, otherwise it is estimated from
+// that many source nodes.
+func (a *adjacency) metrics(samples int) []nodeMetrics {
+	metrics := make([]nodeMetrics, len(a.ids))
+	for i := range a.ids {
+		metrics[i].FanIn = len(a.in[i])
+		metrics[i].FanOut = len(a.out[i])
+		metrics[i].Reach = a.reach(i)
+	}
+	for i, b := range a.betweenness(samples) {
+		metrics[i].Betweenness = b
+	}
+	for i, pr := range a.pageRank(0.85, 100, 1e-9) {
+		metrics[i].PageRank = pr
+	}
+	return metrics
+}
+
+// reach returns the number of nodes transitively referenced from node s.
+func (a *adjacency) reach(s int) int {
+	visited := map[int]struct{}{s: {}}
+	queue := []int{s}
+	for len(queue) != 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range a.out[v] {
+			if _, ok := visited[w]; !ok {
+				visited[w] = struct{}{}
+				queue = append(queue, w)
+			}
+		}
+	}
+	return len(visited) - 1
+}
+
+// betweenness computes the betweenness centrality of every node using
+// Brandes' algorithm.  If samples is non zero and less than the number of
+// nodes, only that many (evenly spaced) source nodes are used and the result
+// is scaled up accordingly.
+func (a *adjacency) betweenness(samples int) []float64 {
+	n := len(a.ids)
+	cb := make([]float64, n)
+
+	step := 1
+	if samples > 0 && samples < n {
+		step = n / samples
+	}
+
+	sigma := make([]float64, n)
+	dist := make([]int, n)
+	delta := make([]float64, n)
+	preds := make([][]int, n)
+
+	sources := 0
+	for s := 0; s < n; s += step {
+		sources++
+		for i := range n {
+			sigma[i] = 0
+			dist[i] = -1
+			delta[i] = 0
+			preds[i] = preds[i][:0]
+		}
+		sigma[s] = 1
+		dist[s] = 0
+
+		var order []int
+		queue := []int{s}
+		for len(queue) != 0 {
+			v := queue[0]
+			queue = queue[1:]
+			order = append(order, v)
+			for _, w := range a.out[v] {
+				if dist[w] < 0 {
+					dist[w] = dist[v] + 1
+					queue = append(queue, w)
+				}
+				if dist[w] == dist[v]+1 {
+					sigma[w] += sigma[v]
+					preds[w] = append(preds[w], v)
+				}
+			}
+		}
+
+		for i := len(order) - 1; i >= 0; i-- {
+			w := order[i]
+			for _, v := range preds[w] {
+				delta[v] += sigma[v] / sigma[w] * (1 + delta[w])
+			}
+			if w != s {
+				cb[w] += delta[w]
+			}
+		}
+	}
+
+	if sources != 0 && sources != n {
+		scale := float64(n) / float64(sources)
+		for i := range cb {
+			cb[i] *= scale
+		}
+	}
+	return cb
+}
+
+// pageRank computes the PageRank of every node, with rank flowing along
+// references, so heavily referenced nodes rank highly.  The rank of nodes
+// with no references is shared between all nodes.
+func (a *adjacency) pageRank(damping float64, maxIterations int, tolerance float64) []float64 {
+	n := len(a.ids)
+	if n == 0 {
+		return nil
+	}
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+
+	next := make([]float64, n)
+	for range maxIterations {
+		dangling := 0.0
+		for i := range rank {
+			if len(a.out[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i := range rank {
+			if len(a.out[i]) == 0 {
+				continue
+			}
+			share := damping * rank[i] / float64(len(a.out[i]))
+			for _, j := range a.out[i] {
+				next[j] += share
+			}
+		}
+
+		diff := 0.0
+		for i := range rank {
+			diff += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if diff < tolerance {
+			break
+		}
+	}
+	return rank
+}
+
+// nodeTypesFilter returns a function reporting whether a node is of one of
+// the named types, or one of moduleAndTableTypes if none are given.
+func nodeTypesFilter(types []string) (func(nodeId) bool, error) {
+	wanted := make(map[nodeType]struct{})
+	for _, s := range types {
+		nt, err := parseNodeType(s)
+		if err != nil {
+			return nil, err
+		}
+		wanted[nt] = struct{}{}
+	}
+	if len(wanted) == 0 {
+		for _, nt := range moduleAndTableTypes {
+			wanted[nt] = struct{}{}
+		}
+	}
+	return func(id nodeId) bool {
+		_, ok := wanted[id.Type]
+		return ok
+	}, nil
+}
+
+// applyMetrics computes the metrics for the graph and stores them as node
+// properties, to be included in the output.
+func (g *graph) applyMetrics(samples int) {
+	include, _ := nodeTypesFilter(nil)
+	a := g.newAdjacency(include)
+	for i, m := range a.metrics(samples) {
+		g.setProperties(a.ids[i], m.properties())
+	}
+}
+
+func (g *graph) writeMetricsTable(types []string, sortBy string, limit int, samples int) error {
+	include, err := nodeTypesFilter(types)
+	if err != nil {
+		return err
+	}
+
+	a := g.newAdjacency(include)
+	metrics := a.metrics(samples)
+
+	var key func(m nodeMetrics) float64
+	switch sortBy {
+	case "fan-in":
+		key = func(m nodeMetrics) float64 { return float64(m.FanIn) }
+	case "fan-out":
+		key = func(m nodeMetrics) float64 { return float64(m.FanOut) }
+	case "reach":
+		key = func(m nodeMetrics) float64 { return float64(m.Reach) }
+	case "betweenness":
+		key = func(m nodeMetrics) float64 { return m.Betweenness }
+	case "pagerank":
+		key = func(m nodeMetrics) float64 { return m.PageRank }
+	default:
+		return fmt.Errorf("unknown metric to sort by : '%s'", sortBy)
+	}
+
+	order := make([]int, len(a.ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return key(metrics[order[i]]) > key(metrics[order[j]]) })
+	if limit > 0 && limit < len(order) {
+		order = order[:limit]
+	}
+
+	tw := tablewriter.NewWriter(os.Stdout)
+	tw.Header([]string{"name", "type", "fan in", "fan out", "reach", "betweenness", "pagerank"})
+	for _, i := range order {
+		m := metrics[i]
+		id := a.ids[i]
+		tw.Append([]string{
+			g.label(id),
+			id.Type.String(),
+			strconv.Itoa(m.FanIn),
+			strconv.Itoa(m.FanOut),
+			strconv.Itoa(m.Reach),
+			strconv.FormatFloat(m.Betweenness, 'f', 1, 64),
+			strconv.FormatFloat(m.PageRank, 'g', 4, 64),
+		})
+	}
+	tw.Render()
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), newNodeId("c", ntTable)},
+		[2]nodeId{method("b"), newNodeId("f", ntField)},
+	)
+
+	include, err := nodeTypesFilter(nil)
+	assert.NoError(err)
+
+	a := g.newAdjacency(include)
+	assert.Equal([]nodeId{method("a"), method("b"), newNodeId("c", ntTable)}, a.ids)
+
+	metrics := a.metrics(0)
+
+	assert.Equal(0, metrics[0].FanIn)
+	assert.Equal(1, metrics[0].FanOut)
+	assert.Equal(2, metrics[0].Reach)
+
+	assert.Equal(1, metrics[1].FanIn)
+	assert.Equal(1, metrics[1].FanOut)
+	assert.Equal(1, metrics[1].Reach)
+
+	// Only b is on the path between two other nodes
+	assert.Equal(0.0, metrics[0].Betweenness)
+	assert.Equal(1.0, metrics[1].Betweenness)
+	assert.Equal(0.0, metrics[2].Betweenness)
+
+	total := 0.0
+	for _, m := range metrics {
+		total += m.PageRank
+	}
+	assert.InDelta(1.0, total, 1e-6)
+	assert.Greater(metrics[2].PageRank, metrics[1].PageRank)
+	assert.Greater(metrics[1].PageRank, metrics[0].PageRank)
+}
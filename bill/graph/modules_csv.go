@@ -0,0 +1,315 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// csvColumn is a column to locate in the header of a CSV export, by its
+// known names, most specific first.  Older exports used a fixed layout, so if
+// none of the required columns are found by name, their historical positions
+// are used instead.  Names must be specific to the table (e.g. not just
+// "logic"), as an export may have other columns that would match.  An
+// optional column that cannot be found is left out.
+type csvColumn struct {
+	names    []string
+	position int
+	optional bool
+}
+
+var (
+	modulesNameColumn  = csvColumn{names: []string{"modname"}, position: 0}
+	modulesLogicColumn = csvColumn{names: []string{"modlogic"}, position: 6}
+	modudetDateColumn  = csvColumn{names: []string{"modudetdate"}, position: 0}
+	modudetLogicColumn = csvColumn{names: []string{"modudetlogic"}, position: 13}
+
+	modudetSoftTypeColumn  = csvColumn{names: []string{"modudetsofttype"}, position: 6, optional: true}
+	modudetLastParamColumn = csvColumn{names: []string{"modudetlastparam"}, position: 7, optional: true}
+)
+
+// clientServer is the ModuDet software type of calls to modules.  ModuDet
+// also records other software types, which are not counted as usage.
+const clientServer = "Client/Server"
+
+// normaliseHeader allows for differences in case and word separators between
+// exports, e.g. "ModName", "mod_name" and "MOD NAME".
+func normaliseHeader(h string) string {
+	h = strings.TrimPrefix(h, "\ufeff") // byte order mark
+	h = strings.ToLower(strings.TrimSpace(h))
+	return strings.NewReplacer("_", "", " ", "", "-", "").Replace(h)
+}
+
+// find returns the index of the column in the header, trying the names in
+// order, or false if none of them are found.
+func (c csvColumn) find(header []string) (int, bool) {
+	for _, name := range c.names {
+		for i, h := range header {
+			if normaliseHeader(h) == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// locate returns the index of each column in the header, or -1 for a missing
+// optional column.  The historical positions are only used if no required
+// column is found by name, as otherwise the header is not of the historical
+// layout, and the position of a missing column would be some other column.
+func locate(filename string, header []string, columns []csvColumn) ([]int, error) {
+	indexes := make([]int, len(columns))
+	found := make([]bool, len(columns))
+	named := false
+	for i, col := range columns {
+		indexes[i], found[i] = col.find(header)
+		if found[i] && !col.optional {
+			named = true
+		}
+	}
+
+	for i, col := range columns {
+		switch {
+		case found[i]:
+		case !named && col.position < len(header):
+			log.Printf("%s : no %s column in header %v, assuming column %d", filename, col.names[0], header, col.position+1)
+			indexes[i] = col.position
+		case col.optional:
+			indexes[i] = -1
+		default:
+			return nil, fmt.Errorf("%s : no %s column in header %v", filename, col.names[0], header)
+		}
+	}
+	return indexes, nil
+}
+
+// csvFile reads a CSV export, locating columns by the header, and reporting
+// problems with the file name and line number.
+type csvFile struct {
+	filename string
+	r        *csv.Reader
+	f        *os.File
+	columns  []int
+}
+
+func openCsv(filename string, columns ...csvColumn) (*csvFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s : %w", filename, err)
+	}
+
+	r := csv.NewReader(bufio.NewReader(f))
+	r.ReuseRecord = true
+	r.TrimLeadingSpace = true
+	// Row lengths are checked against the located columns instead
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read header from %s : %w", filename, err)
+	}
+
+	indexes, err := locate(filename, header, columns)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvFile{filename: filename, r: r, f: f, columns: indexes}, nil
+}
+
+// read returns the located columns of the next row, in the order they were
+// given to openCsv, or io.EOF.  Missing optional columns are empty.
+func (c *csvFile) read() ([]string, error) {
+	rec, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", c.filename, err)
+	}
+
+	values := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		if col < 0 {
+			continue
+		}
+		if col >= len(rec) {
+			line, _ := c.r.FieldPos(0)
+			return nil, fmt.Errorf("%s line %d : expected at least %d fields but found %d", c.filename, line, col+1, len(rec))
+		}
+		values[i] = rec[col]
+	}
+	return values, nil
+}
+
+// has reports whether the i'th column given to openCsv was found.
+func (c *csvFile) has(i int) bool {
+	return c.columns[i] >= 0
+}
+
+// errorf reports a problem with the row just read.
+func (c *csvFile) errorf(format string, a ...any) error {
+	line, _ := c.r.FieldPos(0)
+	return fmt.Errorf("%s line %d : %s", c.filename, line, fmt.Sprintf(format, a...))
+}
+
+func (c *csvFile) Close() error {
+	return c.f.Close()
+}
+
+// readModulesCsv reads a ModuleS export, returning module names by logic id.
+func readModulesCsv(filename string) (map[string]string, error) {
+	c, err := openCsv(filename, modulesNameColumn, modulesLogicColumn)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	modNames := make(map[string]string)
+	for {
+		rec, err := c.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, logic := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if logic == "" {
+			return nil, c.errorf("empty logic id for module '%s'", name)
+		}
+		modNames[logic] = name
+	}
+	return modNames, nil
+}
+
+// readModudetCsv reads a ModuDet export, returning the usage by logic id
+// from since until until, inclusive.  A zero until means no limit.
+func readModudetCsv(filename string, since time.Time, until time.Time) (map[string]*usage, error) {
+	used := make(map[string]*usage)
+	err := eachModudetCall(filename, since, until, func(call modudetCall) {
+		u, ok := used[call.logic]
+		if !ok {
+			u = &usage{}
+			used[call.logic] = u
+		}
+		u.add(call.date)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return used, nil
+}
+
+// modudetCall is a call to a module recorded in ModuDet.
+type modudetCall struct {
+	logic string
+	date  time.Time
+	// caller is the last parameter, which for Client/Server calls is the
+	// full name of the calling module, if any
+	caller string
+}
+
+// eachModudetCall calls fn with each Client/Server call in a ModuDet export
+// from since until until, inclusive.  A zero until means no limit.  Exports
+// without a software type column are assumed to only have Client/Server
+// calls.
+func eachModudetCall(filename string, since time.Time, until time.Time, fn func(call modudetCall)) error {
+	c, err := openCsv(filename, modudetDateColumn, modudetLogicColumn, modudetSoftTypeColumn, modudetLastParamColumn)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if !c.has(2) {
+		log.Printf("%s : no %s column, counting every call", filename, modudetSoftTypeColumn.names[0])
+	}
+
+	for {
+		rec, err := c.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if c.has(2) && strings.TrimSpace(rec[2]) != clientServer {
+			continue
+		}
+
+		date, err := time.Parse(dateFormat, strings.TrimSpace(rec[0]))
+		if err != nil {
+			return c.errorf("invalid date '%s'", rec[0])
+		}
+		if date.Before(since) || (!until.IsZero() && date.After(until)) {
+			continue
+		}
+
+		logic := strings.TrimSpace(rec[1])
+		if logic == "" {
+			return c.errorf("empty logic id")
+		}
+		fn(modudetCall{logic: logic, date: date, caller: strings.TrimSpace(rec[3])})
+	}
+}
+
+// ModuleCallsByMonth returns the number of calls to each module in each
+// month from from to to, inclusive, by full module name (as in ModuleS) and
+// the first day of the month, from ModuleS and ModuDet CSV exports.  A zero
+// to means no limit.  ModuDet logic ids that are not in ModuleS are reported
+// and skipped.
+func ModuleCallsByMonth(modulesCsv string, modudetCsv string, from time.Time, to time.Time) (map[string]map[time.Time]int, error) {
+	modNames, err := readModulesCsv(modulesCsv)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := make(map[string]struct{})
+	counts := make(map[string]map[time.Time]int)
+	err = eachModudetCall(modudetCsv, from, to, func(call modudetCall) {
+		name, ok := modNames[call.logic]
+		if !ok {
+			if _, reported := unknown[call.logic]; !reported {
+				log.Printf("unknown module with logic id %s - skipping", call.logic)
+				unknown[call.logic] = struct{}{}
+			}
+			return
+		}
+		month := time.Date(call.date.Year(), call.date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if counts[name] == nil {
+			counts[name] = make(map[time.Time]int)
+		}
+		counts[name][month]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// ModuleNames returns the distinct full names of the modules (e.g.
+// "NRG_SWEEP2.jcl") in a ModuleS export, sorted.  A name may be registered
+// under more than one logic id.
+func ModuleNames(modulesCsv string) ([]string, error) {
+	modNames, err := readModulesCsv(modulesCsv)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(modNames))
+	for _, name := range modNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return slices.Compact(names), nil
+}
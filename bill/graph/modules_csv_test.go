@@ -0,0 +1,193 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadModulesCsv(t *testing.T) {
+	assert := assert.New(t)
+
+	modNames, err := readModulesCsv(writeTestFile(t, "modules.csv", "\ufeffMOD_LOGIC,ModName\n1, NRG_SWEEP2.jcl\n2,CUSTFORM.frm\n"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{"1": "NRG_SWEEP2.jcl", "2": "CUSTFORM.frm"}, modNames)
+
+	_, err = readModulesCsv(writeTestFile(t, "modules.csv", "ModLogic,ModName\n1,NRG_SWEEP2.jcl\n,CUSTFORM.frm\n"))
+	assert.ErrorContains(err, "line 3 : empty logic id for module 'CUSTFORM.frm'")
+
+	// Other columns with similar names are not mistaken for the module's
+	modNames, err = readModulesCsv(writeTestFile(t, "modules.csv", "Name,Logic,ModName,ModLogic\nuser,9,NRG_SWEEP2.jcl,1\n"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{"1": "NRG_SWEEP2.jcl"}, modNames)
+
+	// Without recognised headers, the historical positions are used
+	modNames, err = readModulesCsv(writeTestFile(t, "modules.csv", "Name,b,c,d,e,f,Logic\nNRG_SWEEP2.jcl,,,,,,1\n"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{"1": "NRG_SWEEP2.jcl"}, modNames)
+}
+
+func TestReadModudetCsv(t *testing.T) {
+	assert := assert.New(t)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	used, err := readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetLogic\n2023-12-31,1\n2024-01-01,1\n2024-03-01,1\n2024-02-01,2\n"), since, time.Time{})
+	assert.NoError(err)
+	assert.Equal(map[string]*usage{
+		"1": {calls: 2, first: since, last: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		"2": {calls: 1, first: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), last: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}, used)
+
+	used, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetLogic\n2024-01-01,1\n2024-02-01,1\n2024-02-02,1\n"), since, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(err)
+	assert.Equal(2, used["1"].calls)
+
+	_, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetLogic\n2024-01-01,1\n01/02/2024,1\n"), since, time.Time{})
+	assert.ErrorContains(err, "line 3 : invalid date '01/02/2024'")
+
+	_, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetLogic,ModuDetDate\n1\n"), since, time.Time{})
+	assert.ErrorContains(err, "line 2 : expected at least 2 fields but found 1")
+
+	_, err = readModudetCsv(writeTestFile(t, "modudet.csv", "Foo\n1\n"), since, time.Time{})
+	assert.ErrorContains(err, "no modudetlogic column")
+
+	// Only Client/Server calls are counted
+	used, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetSoftType,ModuDetLogic\n2024-01-01,Client/Server,1\n2024-01-02,Other,1\n2024-01-03,,2\n"), since, time.Time{})
+	assert.NoError(err)
+	assert.Equal(map[string]*usage{"1": {calls: 1, first: since, last: since}}, used)
+
+	// With a named header, the soft type is not assumed to be the 7th column
+	used, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,b,c,d,e,f,g,h,ModuDetLogic\n2024-01-01,,,,,,Other,,1\n"), since, time.Time{})
+	assert.NoError(err)
+	assert.Equal(map[string]*usage{"1": {calls: 1, first: since, last: since}}, used)
+
+	// Nor is a missing required column
+	_, err = readModudetCsv(writeTestFile(t, "modudet.csv", "ModuDetDate,b,c,d,e,f,g,h,i,j,k,l,m,n\n2024-01-01,,,,,,,,,,,,,1\n"), since, time.Time{})
+	assert.ErrorContains(err, "no modudetlogic column")
+}
+
+func TestApplyModules(t *testing.T) {
+	assert := assert.New(t)
+
+	form := newNodeId("custform", ntForm)
+	g := testGraph(
+		[2]nodeId{form, method("a")},
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), newNodeId("t", ntTable)},
+	)
+
+	modulesCsv := writeTestFile(t, "modules.csv", "ModName,ModLogic\nCUSTFORM.frm,1\nA.jcl,2\nB.jcl,3\n")
+	modudetCsv := writeTestFile(t, "modudet.csv", `ModuDetDate,ModuDetSoftType,ModuDetLastParam,ModuDetLogic
+2024-01-01,Client/Server,,1
+2024-01-01,Client/Server,CUSTFORM.frm,2
+2024-01-02,Client/Server,CUSTFORM.frm,2
+2024-01-02,Client/Server,CUSTFORM.frm,3
+2024-01-03,Other,A.jcl,3
+2024-01-03,Client/Server,A.jcl,4
+`)
+
+	assert.NoError(g.applyModules(modulesCsv, modudetCsv, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), unknownLogicIgnore))
+	assert.Equal(map[nodeId]string{
+		form:        reasonModuDet,
+		method("a"): reasonModuDet,
+		method("b"): reasonModuDet,
+	}, g.entryPoints)
+	// Calls without a caller, of other software types and to unknown
+	// modules are not observed calls
+	assert.Equal(map[[2]nodeId]int{
+		{form, method("a")}: 2,
+		{form, method("b")}: 1,
+	}, g.observed)
+}
+
+func TestApplyUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("nrg_sweep2"), method("a")},
+		[2]nodeId{method("nrg_sweep3"), method("a")},
+		[2]nodeId{newNodeId("custform", ntForm), method("a")},
+	)
+
+	modNames := map[string]string{
+		"1": "NRG_SWEEP", // truncated, ambiguous
+		"2": "CUSTFO",    // truncated
+		"3": "A.jcl",
+	}
+	u := &usage{calls: 1}
+	used := map[string]*usage{"1": u, "2": u, "3": u, "4": u}
+
+	assert.ErrorContains(g.applyUsage(modNames, used, unknownLogicError), "unknown module with logic id 4")
+
+	g.entryPoints = make(map[nodeId]string)
+	assert.NoError(g.applyUsage(modNames, used, unknownLogicIgnore))
+	assert.Equal(map[nodeId]string{
+		newNodeId("custform", ntForm): reasonModuDet,
+		method("a"):                   reasonModuDet,
+	}, g.entryPoints)
+}
+
+func TestModuleCallCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	modulesCsv := writeTestFile(t, "modules.csv", "ModName,ModLogic\nA.jcl,1\nB.frm,2\n")
+	modudetCsv := writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetLogic\n2023-12-31,1\n2024-01-01,1\n2024-06-01,2\n2024-07-01,1\n2024-07-01,3\n")
+
+	counts, err := ModuleCallCounts(modulesCsv, modudetCsv, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), "")
+	assert.NoError(err)
+	assert.Equal(map[string]int{"A.jcl": 1, "B.frm": 1}, counts)
+
+	counts, err = ModuleCallCounts(modulesCsv, modudetCsv, time.Time{}, time.Time{}, unknownLogicIgnore)
+	assert.NoError(err)
+	assert.Equal(map[string]int{"A.jcl": 3, "B.frm": 1}, counts)
+
+	_, err = ModuleCallCounts(modulesCsv, modudetCsv, time.Time{}, time.Time{}, unknownLogicError)
+	assert.ErrorContains(err, "unknown module with logic id 3")
+
+	_, err = ModuleCallCounts(modulesCsv, modudetCsv, time.Time{}, time.Time{}, "sometimes")
+	assert.ErrorContains(err, "unknown handling for unknown logic ids : 'sometimes'")
+
+	// Only Client/Server calls are counted, as from the Postgres mirror
+	modudetCsv = writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetSoftType,ModuDetLogic\n2024-01-01,Client/Server,1\n2024-01-01,Other,1\n2024-01-01,Other,2\n")
+	counts, err = ModuleCallCounts(modulesCsv, modudetCsv, time.Time{}, time.Time{}, "")
+	assert.NoError(err)
+	assert.Equal(map[string]int{"A.jcl": 1}, counts)
+}
+
+func TestModuleCallsByMonth(t *testing.T) {
+	assert := assert.New(t)
+
+	modulesCsv := writeTestFile(t, "modules.csv", "ModName,ModLogic\nA.jcl,1\nB.frm,2\n")
+	modudetCsv := writeTestFile(t, "modudet.csv", "ModuDetDate,ModuDetLogic\n2023-12-31,1\n2024-01-01,1\n2024-01-31,1\n2024-06-01,2\n2024-07-01,1\n2024-07-01,3\n")
+
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	jul := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	counts, err := ModuleCallsByMonth(modulesCsv, modudetCsv, jan, time.Time{})
+	assert.NoError(err)
+	assert.Equal(map[string]map[time.Time]int{
+		"A.jcl": {jan: 2, jul: 1},
+		"B.frm": {jun: 1},
+	}, counts)
+}
+
+func TestModuleNames(t *testing.T) {
+	assert := assert.New(t)
+
+	names, err := ModuleNames(writeTestFile(t, "modules.csv", "ModName,ModLogic\nB.frm,1\nA.jcl,2\nB.frm,3\n"))
+	assert.NoError(err)
+	assert.Equal([]string{"A.jcl", "B.frm"}, names)
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// moduleUsageQuery gets usage per module from the Bill Postgres mirror,
+// with the same semantics as the ModuleS and ModuDet CSV exports, counting
+// only Client/Server calls like observedCallsQuery.
+const moduleUsageQuery = `select
+	mods.modname, count(*), min(dets.modudetdate), max(dets.modudetdate)
+	from equinox.modules mods
+	inner join equinox.modudet dets on mods.equinox_lrn = dets.equinox_prn and modudetsofttype='Client/Server'
+	where dets.modudetdate >= $1
+	group by mods.modname
+	;
+`
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// readUsagePostgres returns the usage since the given time by full module
+// name.
+func readUsagePostgres(db queryer, since time.Time) (map[string]*usage, error) {
+	rows, err := db.Query(moduleUsageQuery, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query module usage : %w", err)
+	}
+	defer rows.Close()
+
+	used := make(map[string]*usage)
+	for rows.Next() {
+		var name string
+		u := &usage{}
+		if err := rows.Scan(&name, &u.calls, &u.first, &u.last); err != nil {
+			return nil, err
+		}
+		used[name] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return used, nil
+}
+
+// applyModulesPostgres is applyModules, reading usage and the calls between
+// modules from the Postgres mirror rather than CSV exports.
+func (g *graph) applyModulesPostgres(dsn string, since time.Time) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	used, err := readUsagePostgres(db, since)
+	if err != nil {
+		return err
+	}
+
+	g.applyUsageByName(used)
+
+	calls, err := readObservedCallsPostgres(db, since)
+	if err != nil {
+		return err
+	}
+	if unmatched := g.applyObservedCalls(calls); unmatched != 0 {
+		log.Printf("%d observed calls not matched to modules in the source - skipping", unmatched)
+	}
+
+	return nil
+}
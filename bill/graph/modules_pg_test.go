@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReadUsagePostgres needs a local Postgres, e.g.
+//
+//	BILLSOURCERY_TEST_DSN="postgres://postgres@localhost:5432/postgres?sslmode=disable" go test ./bill/graph
+//
+// Everything is done in a transaction that is rolled back.
+func TestReadUsagePostgres(t *testing.T) {
+	dsn := os.Getenv("BILLSOURCERY_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BILLSOURCERY_TEST_DSN not set")
+	}
+
+	assert := assert.New(t)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`create schema equinox`,
+		`create table equinox.modules (equinox_lrn integer, modname text)`,
+		`create table equinox.modudet (equinox_prn integer, modudetdate date, modudetlastparam text, modudetsofttype text)`,
+		`insert into equinox.modules values (1, 'NRG_SWEEP2.jcl'), (2, 'CUSTFORM.frm'), (3, 'OLD.jcl')`,
+		`insert into equinox.modudet values
+			(1, '2024-02-01', 'CUSTFORM.frm', 'Client/Server'),
+			(1, '2024-05-01', 'CUSTFORM.frm', 'Client/Server'),
+			(2, '2024-03-01', '', 'Other'),
+			(3, '2023-01-01', 'CUSTFORM.frm', 'Client/Server')`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	used, err := readUsagePostgres(tx, date(2024, 1, 1))
+	assert.NoError(err)
+	// Only Client/Server calls are counted, as for observed calls
+	assert.Len(used, 1)
+	assert.Equal(2, used["NRG_SWEEP2.jcl"].calls)
+	assert.Equal("2024-02-01", used["NRG_SWEEP2.jcl"].first.Format(dateFormat))
+	assert.Equal("2024-05-01", used["NRG_SWEEP2.jcl"].last.Format(dateFormat))
+
+	calls, err := readObservedCallsPostgres(tx, date(2024, 1, 1))
+	assert.NoError(err)
+	assert.Equal([]observedCall{{caller: "CUSTFORM.frm", callee: "NRG_SWEEP2.jcl", count: 2}}, calls)
+}
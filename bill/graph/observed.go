@@ -0,0 +1,145 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// observedCallsQuery gets the calls between modules seen in production from
+// the Bill Postgres mirror, in the same way as calls-stats-table.
+const observedCallsQuery = `select
+	dets.modudetlastparam caller, mods.modname callto, count(*)
+	from equinox.modules mods
+	inner join equinox.modudet dets on mods.equinox_lrn = dets.equinox_prn and modudetsofttype='Client/Server'
+	where dets.modudetdate >= $1
+	group by caller, callto
+	;
+`
+
+// observedCall is a call from one module to another seen in production, by
+// full module name (as in ModuleS).
+type observedCall struct {
+	caller string
+	callee string
+	count  int
+}
+
+// readObservedCallsPostgres returns the calls between modules since the
+// given time.
+func readObservedCallsPostgres(db queryer, since time.Time) ([]observedCall, error) {
+	rows, err := db.Query(observedCallsQuery, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query observed calls : %w", err)
+	}
+	defer rows.Close()
+
+	var calls []observedCall
+	for rows.Next() {
+		var c observedCall
+		if err := rows.Scan(&c.caller, &c.callee, &c.count); err != nil {
+			return nil, err
+		}
+		calls = append(calls, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return calls, nil
+}
+
+// applyObservedCalls adds the calls between modules that are both in the
+// graph, and returns the number of calls that could not be matched to
+// modules in the graph, e.g. because the caller is not a module.
+func (g *graph) applyObservedCalls(calls []observedCall) int {
+	resolve := g.moduleNameResolver()
+
+	unmatched := 0
+	for _, c := range calls {
+		caller, err := resolve(c.caller)
+		if err != nil {
+			unmatched += c.count
+			continue
+		}
+		callee, err := resolve(c.callee)
+		if err != nil {
+			unmatched += c.count
+			continue
+		}
+		_, callerOk := g.nodes[caller]
+		_, calleeOk := g.nodes[callee]
+		if !callerOk || !calleeOk {
+			unmatched += c.count
+			continue
+		}
+		g.observed[[2]nodeId{caller, callee}] += c.count
+	}
+	return unmatched
+}
+
+func (g *graph) observedSorted() [][2]nodeId {
+	calls := make([][2]nodeId, 0, len(g.observed))
+	for call := range g.observed {
+		calls = append(calls, call)
+	}
+	sortRefs(calls)
+	return calls
+}
+
+func sortRefs(refs [][2]nodeId) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i][0] != refs[j][0] {
+			return refs[i][0].id() < refs[j][0].id()
+		}
+		return refs[i][1].id() < refs[j][1].id()
+	})
+}
+
+// compareObserved compares the observed calls with the static references.
+// It returns the observed calls with no static reference, which indicate
+// dynamic dispatch the static analysis misses, busiest first, and the
+// static calls that were never observed from modules that were observed
+// calling something, which indicate dead branches.
+func (g *graph) compareObserved() (missed [][2]nodeId, unobserved [][2]nodeId) {
+	observedCallers := make(map[nodeId]struct{})
+	for _, call := range g.observedSorted() {
+		observedCallers[call[0]] = struct{}{}
+		if _, ok := g.nodes[call[0]].Refs[call[1]]; !ok {
+			missed = append(missed, call)
+		}
+	}
+	sort.SliceStable(missed, func(i, j int) bool { return g.observed[missed[i]] > g.observed[missed[j]] })
+
+	for _, n := range g.nodesSorted() {
+		if _, ok := observedCallers[n.nodeId]; !ok {
+			continue
+		}
+		for _, ref := range n.refsSorted() {
+			if _, ok := moduleExtensions[ref.Type]; !ok {
+				continue
+			}
+			if _, ok := g.observed[[2]nodeId{n.nodeId, ref}]; !ok {
+				unobserved = append(unobserved, [2]nodeId{n.nodeId, ref})
+			}
+		}
+	}
+
+	return missed, unobserved
+}
+
+func (g *graph) writeObservedReport(w io.Writer) {
+	missed, unobserved := g.compareObserved()
+
+	fmt.Fprintf(w, "observed calls : %d\n", len(g.observed))
+
+	fmt.Fprintf(w, "observed calls not found by static analysis : %d\n", len(missed))
+	for _, call := range missed {
+		fmt.Fprintf(w, "\t%d\t%s\n", g.observed[call], g.formatPath(call[:]))
+	}
+
+	fmt.Fprintf(w, "static calls never observed, from modules observed calling others : %d\n", len(unobserved))
+	for _, call := range unobserved {
+		fmt.Fprintf(w, "\t%s\n", g.formatPath(call[:]))
+	}
+}
@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservedCalls(t *testing.T) {
+	assert := assert.New(t)
+
+	form := newNodeId("custform", ntForm)
+	g := testGraph(
+		[2]nodeId{form, method("a")},
+		[2]nodeId{form, method("b")},
+		[2]nodeId{form, newNodeId("t", ntTable)},
+		[2]nodeId{method("a"), method("c")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("d")},
+		[2]nodeId{method("d"), method("e")},
+		[2]nodeId{method("e"), newNodeId("t", ntTable)},
+	)
+
+	unmatched := g.applyObservedCalls([]observedCall{
+		{caller: "CUSTFORM.frm", callee: "A.jcl", count: 10},
+		{caller: "CUSTFO", callee: "A.jcl", count: 5},   // truncated
+		{caller: "A.jcl", callee: "D.jcl", count: 3},    // dynamic
+		{caller: "A.jcl", callee: "E.jcl", count: 7},    // dynamic
+		{caller: "SYSTEM", callee: "A.jcl", count: 100}, // not a module
+		{caller: "A.jcl", callee: "ZZ.jcl", count: 1},   // not in the source
+	})
+	assert.Equal(101, unmatched)
+	assert.Equal(map[[2]nodeId]int{
+		{form, method("a")}:        15,
+		{method("a"), method("d")}: 3,
+		{method("a"), method("e")}: 7,
+	}, g.observed)
+
+	missed, unobserved := g.compareObserved()
+	assert.Equal([][2]nodeId{
+		{method("a"), method("e")},
+		{method("a"), method("d")},
+	}, missed)
+	// Nothing is known about b to e, as they were never observed calling
+	// anything, and tables are not calls
+	assert.Equal([][2]nodeId{
+		{method("a"), method("c")},
+		{form, method("b")},
+	}, unobserved)
+}
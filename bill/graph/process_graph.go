@@ -2,17 +2,15 @@ package graph
 
 import (
 	"bufio"
-	"encoding/csv"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"slices"
 	"sort"
 	"strconv"
-	"time"
 
 	"strings"
 
@@ -23,14 +21,47 @@ import (
 
 func newGraph() *graph {
 	return &graph{
-		nodes: make(map[nodeId]*node),
-		used:  make(map[nodeId]struct{}),
+		nodes:       make(map[nodeId]*node),
+		used:        make(map[nodeId]struct{}),
+		entryPoints: make(map[nodeId]string),
+		live:        make(map[nodeId]struct{}),
+		props:       make(map[nodeId]properties),
+		refProps:    make(map[[2]nodeId]properties),
+		files:       make(map[string][]nodeId),
+		observed:    make(map[[2]nodeId]int),
 	}
 }
 
 type graph struct {
 	nodes map[nodeId]*node
 	used  map[nodeId]struct{}
+	// entryPoints are the nodes known to be invoked from outside the source
+	// code, e.g. by the system or by users, with the reason we know that
+	entryPoints map[nodeId]string
+	// live is populated by markLive
+	live map[nodeId]struct{}
+	// props are additional values to include in the output for each node
+	props map[nodeId]properties
+	// refProps are additional values to include in the output for each
+	// reference, keyed by from and to node
+	refProps map[[2]nodeId]properties
+	// files are the nodes defined in each source file, keyed by path (within
+	// the source root, once built)
+	files map[string][]nodeId
+	// observed are the calls between modules seen in production, according
+	// to ModuDet, with the number of calls, keyed by caller and callee
+	observed map[[2]nodeId]int
+}
+
+func (g *graph) setProperties(id nodeId, props properties) {
+	existing, ok := g.props[id]
+	if !ok {
+		existing = make(properties)
+		g.props[id] = existing
+	}
+	for k, v := range props {
+		existing[k] = v
+	}
 }
 
 func (c *graph) addNode(node *node) {
@@ -77,15 +108,15 @@ func (c *graph) writeGraph(output graphOutput) error {
 	for _, n := range allNodes {
 		id := sanitiseId(n.id())
 
-		var labels []string
-		_, used := c.used[n.nodeId]
-		if used {
-			labels = []string{n.Type.String(), "used"}
-		} else {
-			labels = []string{n.Type.String()}
+		labels := []string{n.Type.String()}
+		if _, used := c.used[n.nodeId]; used {
+			labels = append(labels, "used")
+		}
+		if _, live := c.live[n.nodeId]; live {
+			labels = append(labels, "live")
 		}
 
-		if err := output.AddNode(id, n.Label, labels); err != nil {
+		if err := output.AddNode(id, n.Label, labels, c.props[n.nodeId]); err != nil {
 			return err
 		}
 	}
@@ -99,12 +130,18 @@ func (c *graph) writeGraph(output graphOutput) error {
 				missingRefs[toModule] = struct{}{}
 			}
 
-			if err := output.AddReference(sanitiseId(fromModule.id()), sanitiseId(toModule.id())); err != nil {
+			if err := output.AddReference(sanitiseId(fromModule.id()), sanitiseId(toModule.id()), kindOf(fromModule.nodeId, toModule), c.refProps[[2]nodeId{fromModule.nodeId, toModule}]); err != nil {
 				return err
 			}
 		}
 	}
 
+	for _, call := range c.observedSorted() {
+		if err := output.AddReference(sanitiseId(call[0].id()), sanitiseId(call[1].id()), rkObserved, properties{"count": c.observed[call]}); err != nil {
+			return err
+		}
+	}
+
 	missingSorted := make([]nodeId, 0, len(missingRefs))
 	for missing := range missingRefs {
 		missingSorted = append(missingSorted, missing)
@@ -112,7 +149,7 @@ func (c *graph) writeGraph(output graphOutput) error {
 	sort.Slice(missingSorted, func(i int, j int) bool { return missingSorted[i].Name < missingSorted[j].Name })
 
 	for _, n := range missingSorted {
-		if err := output.AddNode(sanitiseId(n.id()), n.Name, []string{n.Type.String(), "missing"}); err != nil {
+		if err := output.AddNode(sanitiseId(n.id()), n.Name, []string{n.Type.String(), "missing"}, c.props[n]); err != nil {
 			return err
 		}
 	}
@@ -207,13 +244,13 @@ func (cb *graph) process(path string) error {
 			}
 
 			if n.nodeId.Type != ntPpl { // Hack. Skip ppl for now because we can't do it properly
-				// Find method calls in text
-				refs, err := findMethodRefs(n.nodeId, text)
+				// Find method, form and report calls in text
+				refs, err := findExecuteRefs(n.nodeId, text)
 				if err != nil {
 					return err
 				}
 				for _, ref := range refs {
-					n.addMethodRef(ref)
+					n.Refs[ref] = struct{}{}
 				}
 			}
 		case "SUB,":
@@ -320,9 +357,12 @@ func (cb *graph) process(path string) error {
 		}
 	}
 
+	defined := ppdsDefined
 	if n.Type != ntPpl {
 		cb.addNode(n)
+		defined = append(defined, n.nodeId)
 	}
+	cb.files[filepath.Clean(path)] = defined
 
 	// Check for LPCs that are really calls to locally decined PPDs
 	for _, call := range lpcsCalls {
@@ -379,93 +419,9 @@ func idAndLabelFromFullName(fullName string) (nodeId, string) {
 	return id, label
 }
 
-func (g *graph) applyModules(modulesCsv, modudetCsv string) error {
-	switch {
-	case modulesCsv == "" && modudetCsv == "":
-		return nil
-	case (modulesCsv == "" && modudetCsv != "") || (modudetCsv == "" && modulesCsv != ""):
-		return errors.New("module CSV files must both be provided")
-	}
-
-	modules, err := os.Open(modulesCsv)
-	if err != nil {
-		return fmt.Errorf("failed to open module file %s : %e", modulesCsv, err)
-	}
-	modulesReader := csv.NewReader(bufio.NewReader(modules))
-	modulesReader.ReuseRecord = true
-	modulesReader.TrimLeadingSpace = true
-
-	// Map of logic id to module name
-	modNames := make(map[string]string)
-
-	// skip header
-	_, _ = modulesReader.Read()
-
-	for {
-		rec, err := modulesReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading modules csv : %e", err)
-		}
-
-		modNames[rec[6]] = rec[0]
-	}
-
-	modudet, err := os.Open(modudetCsv)
-	if err != nil {
-		return fmt.Errorf("failed to open module file %s : %e", modulesCsv, err)
-	}
-	modudetReader := csv.NewReader(bufio.NewReader(modudet))
-	modudetReader.ReuseRecord = true
-	modudetReader.TrimLeadingSpace = true
-
-	since, err := time.Parse("2006-01-02", "2024-01-01")
-	if err != nil {
-		return err
-	}
-
-	usedModuleLogics := make(map[string]struct{})
-
-	// skip header
-	_, _ = modudetReader.Read()
-
-	for {
-		rec, err := modudetReader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading modules csv : %e", err)
-		}
-
-		time, err := time.Parse("2006-01-02", rec[0])
-		if err != nil {
-			return fmt.Errorf("error parsing date '%s' from ModuDet CSV : %e", rec[0], err)
-		}
-		if !time.Before(since) {
-			usedModuleLogics[rec[13]] = struct{}{}
-		}
-	}
-
-	for logic := range usedModuleLogics {
-		name, ok := modNames[logic]
-		if !ok {
-			return fmt.Errorf("unknown module with logic id %s", logic)
-		}
-
-		// Some are truncated. Skip those.
-		if strings.Contains(name, ".") {
-			id, _ := idAndLabelFromFullName(name)
-			if id.Type != "UNKNOWN" {
-				g.used[id] = struct{}{}
-			}
-		}
-	}
-
-	return nil
-}
+// reasonModuDet is the entry point reason for modules found to be used in
+// production according to ModuDet.
+const reasonModuDet = "used according to ModuDet"
 
 // This passes over the graph, and wherever something (e.g., a method)
 // references an index, also ensure we have a direct reference to the
@@ -491,6 +447,9 @@ func (g *graph) applySpecial(specialJson string) error {
 
 	type Special struct {
 		SystemProcedures []string `json:"systemProcedures,omitempty"`
+		// Menus are not part of the source code, so forms reachable from
+		// menus must be listed explicitly.
+		MenuForms []string `json:"menuForms,omitempty"`
 	}
 
 	if specialJson == "" {
@@ -499,20 +458,28 @@ func (g *graph) applySpecial(specialJson string) error {
 
 	f, err := os.Open(specialJson)
 	if err != nil {
-		return fmt.Errorf("failed to open JSON file : %e", err)
+		return fmt.Errorf("failed to open JSON file : %w", err)
 	}
+	defer f.Close()
 	br := bufio.NewReader(f)
 
 	dec := json.NewDecoder(br)
 
 	var special Special
 	if err := dec.Decode(&special); err != nil {
-		return fmt.Errorf("failed to decode JSON file : %e", err)
+		return fmt.Errorf("failed to decode JSON file %s : %w", specialJson, err)
 	}
 
 	for _, procName := range special.SystemProcedures {
 		id := newNodeId(procName, ntPubProc)
 		g.used[id] = struct{}{}
+		g.entryPoints[id] = "system procedure (special JSON)"
+	}
+
+	for _, formName := range special.MenuForms {
+		id := newNodeId(formName, ntForm)
+		g.used[id] = struct{}{}
+		g.entryPoints[id] = "menu form (special JSON)"
 	}
 
 	return nil
@@ -526,7 +493,7 @@ func (g *graph) applySchema(schemaDumpJson string) error {
 
 	f, err := os.Open(schemaDumpJson)
 	if err != nil {
-		return fmt.Errorf("failed to open schema dump file : %e", err)
+		return fmt.Errorf("failed to open schema dump file : %w", err)
 	}
 	br := bufio.NewReader(f)
 
@@ -595,7 +562,7 @@ func (g *graph) applySchema(schemaDumpJson string) error {
 	return nil
 }
 
-func findMethodRefs(fromNodeId nodeId, text string) ([]string, error) {
+func findExecuteRefs(fromNodeId nodeId, text string) ([]nodeId, error) {
 
 	l := equilex.NewLexer(transform.NewReader(strings.NewReader(text), charmap.Windows1252.NewDecoder()))
 
@@ -631,7 +598,25 @@ loop:
 		}
 	}
 
-	var methodsRefs []string
+	var refs []nodeId
+
+	// addRef adds a reference to a module named by a string literal.
+	addRef := func(lit string, nt nodeType, ext string) {
+		if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+			log.Printf("call from %s to variable %s '%s' - skipping", fromNodeId.Name, nt, lit)
+			return
+		}
+
+		to := strings.ToLower(lit)
+		to = to[1 : len(to)-1]
+		to = strings.TrimSuffix(to, ext)
+		if to == "" {
+			log.Printf("call from %s to empty %s name - skipping", fromNodeId.Name, nt)
+			return
+		}
+
+		refs = append(refs, newNodeId(to, nt))
+	}
 
 	for _, stmt := range stmts {
 		toks := stmt.tokens
@@ -642,11 +627,17 @@ loop:
 		case equilex.Export:
 		case equilex.Task:
 		case equilex.Form:
+			if len(toks) > 4 {
+				addRef(toks[4].lit, ntForm, ".frm")
+			}
 		case equilex.FormSwap:
 		case equilex.Query:
 		case equilex.Process:
 		case equilex.System:
 		case equilex.Report:
+			if len(toks) > 4 {
+				addRef(toks[4].lit, ntReport, ".rep")
+			}
 		case equilex.ReportPreview:
 		case equilex.Shell:
 		case equilex.Command:
@@ -663,16 +654,8 @@ loop:
 		case equilex.OptimiseDatabaseHelper:
 		case equilex.ConvertAllDatabases:
 		case equilex.Method:
-			to := toks[4].lit
-
-			if to[0] == '"' && to[len(to)-1] == '"' {
-				to = strings.ToLower(to)
-				to = to[1 : len(to)-1]
-				to = strings.TrimSuffix(to, ".jcl")
-
-				methodsRefs = append(methodsRefs, to)
-			} else {
-				log.Printf("call from %s to variable method '%s' - skipping", fromNodeId.Name, to)
+			if len(toks) > 4 {
+				addRef(toks[4].lit, ntMethod, ".jcl")
 			}
 		default:
 			for i, t := range toks {
@@ -682,7 +665,7 @@ loop:
 		}
 	}
 
-	return methodsRefs, nil
+	return refs, nil
 
 }
 
@@ -729,10 +712,6 @@ func (m node) String() string {
 	return m.Name
 }
 
-func (r *node) addMethodRef(name string) {
-	r.Refs[newNodeId(name, ntMethod)] = struct{}{}
-}
-
 func newNode() *node {
 	return &node{
 		Txt:  make([]string, 0),
@@ -781,6 +760,7 @@ const (
 	ntExport   nodeType = "export"
 	ntField    nodeType = "field"
 	ntForm     nodeType = "form"
+	ntGroup    nodeType = "group" // an aggregate of other nodes
 	ntImport   nodeType = "import"
 	ntIndex    nodeType = "index"
 	ntMethod   nodeType = "method"
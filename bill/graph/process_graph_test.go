@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindExecuteRefs(t *testing.T) {
+	assert := assert.New(t)
+
+	refs, err := findExecuteRefs(method("a"), "Execute Method \"B.jcl\"\r\nExecute Form \"CustForm.frm\"\r\nExecute Report \"Inv.rep\"\r\n")
+	assert.NoError(err)
+	assert.Equal([]nodeId{
+		method("b"),
+		newNodeId("custform", ntForm),
+		newNodeId("inv", ntReport),
+	}, refs)
+
+	// Variables, empty names and missing names are skipped
+	refs, err = findExecuteRefs(method("a"), "Execute Form formName\r\nExecute Report \"\"\r\nExecute Form\r\nExecute Method\r\n")
+	assert.NoError(err)
+	assert.Empty(refs)
+}
+
+// processTestSource builds a graph from source export files, given as the
+// module's full name and its text.
+func processTestSource(t *testing.T, modules ...[2]string) *graph {
+	dir := t.TempDir()
+	g := newGraph()
+	for i, m := range modules {
+		path := fmt.Sprintf("%d.txt", i)
+		writeModule(t, dir, path, m[0], m[1])
+		if err := g.process(filepath.Join(dir, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+// unused returns the first column of the named dead code report.
+func unused(g *graph, liveness bool, report string) []string {
+	var names []string
+	for _, r := range g.deadCode(liveness) {
+		if r.name == report {
+			for _, row := range r.rows {
+				names = append(names, row[0])
+			}
+		}
+	}
+	return names
+}
+
+func TestExecuteFormAndReportRefs(t *testing.T) {
+	assert := assert.New(t)
+
+	g := processTestSource(t,
+		[2]string{"Entry.jcl", "Execute Form \"CustForm.frm\"\r\nExecute Report \"Inv.rep\"\r\n"},
+		[2]string{"CustForm.frm", "Execute Method \"Calc.jcl\"\r\n"},
+		[2]string{"Inv.rep", "\r\n"},
+		[2]string{"Calc.jcl", "\r\n"},
+		[2]string{"Orphan.frm", "\r\n"},
+		[2]string{"OrphanRep.rep", "\r\n"},
+	)
+
+	// Forms and reports executed from code are referenced, so are not
+	// unused
+	assert.Equal([]string{"Orphan"}, unused(g, false, "unused_forms"))
+	assert.Equal([]string{"OrphanRep"}, unused(g, false, "unused_reports"))
+
+	// and liveness follows calls through them
+	g.entryPoints[method("entry")] = "test"
+	g.markLive()
+	assert.Contains(g.live, newNodeId("custform", ntForm))
+	assert.Contains(g.live, newNodeId("inv", ntReport))
+	assert.Contains(g.live, method("calc"))
+	assert.Equal([]string{"Orphan"}, unused(g, true, "unused_forms"))
+	assert.Equal([]string{"OrphanRep"}, unused(g, true, "unused_reports"))
+}
+
+func TestSpecialJson(t *testing.T) {
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	writeModule(t, root, "Methods/a.jc@.txt", "A.jcl", "\r\n")
+
+	writeModule(t, root, "Forms/custform.fr@.txt", "CustForm.frm", "\r\n")
+
+	special := writeTestFile(t, "special.json", `{"systemProcedures": ["AutoExecAfterLogin"], "menuForms": ["CustForm"]}`)
+	g, err := buildGraph(GraphSource{SourceRoot: root, SpecialJson: special})
+	assert.NoError(err)
+	assert.Equal("system procedure (special JSON)", g.entryPoints[newNodeId("autoexecafterlogin", ntPubProc)])
+
+	// Forms opened from menus are live entry points
+	form := newNodeId("custform", ntForm)
+	assert.Equal("menu form (special JSON)", g.entryPoints[form])
+	assert.Contains(g.live, form)
+	assert.Empty(unused(g, true, "unused_forms"))
+
+	// A special JSON given explicitly must exist
+	_, err = buildGraph(GraphSource{SourceRoot: root, SpecialJson: filepath.Join(root, "missing.json")})
+	assert.ErrorContains(err, "failed to open JSON file")
+
+	_, err = buildGraph(GraphSource{SourceRoot: root, SpecialJson: writeTestFile(t, "special.json", "{")})
+	assert.ErrorContains(err, "failed to decode JSON file")
+}
@@ -0,0 +1,159 @@
+package graph
+
+import (
+	"sort"
+)
+
+// stronglyConnected finds the strongly connected components of the graph,
+// considering only nodes (and references between nodes) for which include
+// returns true.  References to missing nodes are ignored.  Components are
+// returned in reverse topological order, that is, a component is always
+// returned before any component that references it.  Nodes within each
+// component are sorted.
+func (g *graph) stronglyConnected(include func(nodeId) bool) [][]nodeId {
+	// Tarjan's algorithm
+	index := make(map[nodeId]int)
+	lowlink := make(map[nodeId]int)
+	onStack := make(map[nodeId]bool)
+	var stack []nodeId
+	var components [][]nodeId
+
+	var strongConnect func(id nodeId)
+	strongConnect = func(id nodeId) {
+		index[id] = len(index)
+		lowlink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		if n, ok := g.nodes[id]; ok {
+			for _, ref := range n.refsSorted() {
+				if !include(ref) {
+					continue
+				}
+				if _, exists := g.nodes[ref]; !exists {
+					continue
+				}
+				if _, visited := index[ref]; !visited {
+					strongConnect(ref)
+					lowlink[id] = min(lowlink[id], lowlink[ref])
+				} else if onStack[ref] {
+					lowlink[id] = min(lowlink[id], index[ref])
+				}
+			}
+		}
+
+		if lowlink[id] == index[id] {
+			var component []nodeId
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == id {
+					break
+				}
+			}
+			sort.Slice(component, func(i, j int) bool { return component[i].id() < component[j].id() })
+			components = append(components, component)
+		}
+	}
+
+	for _, n := range g.nodesSorted() {
+		if !include(n.nodeId) {
+			continue
+		}
+		if _, visited := index[n.nodeId]; !visited {
+			strongConnect(n.nodeId)
+		}
+	}
+
+	return components
+}
+
+// cycles returns the strongly connected components that contain a cycle,
+// that is, those with more than one node, or a single node that references
+// itself.  They are ordered largest first.
+func (g *graph) cycles(include func(nodeId) bool) [][]nodeId {
+	var cycles [][]nodeId
+	for _, component := range g.stronglyConnected(include) {
+		if len(component) > 1 {
+			cycles = append(cycles, component)
+			continue
+		}
+		if n, ok := g.nodes[component[0]]; ok {
+			if _, self := n.Refs[component[0]]; self {
+				cycles = append(cycles, component)
+			}
+		}
+	}
+	sort.SliceStable(cycles, func(i, j int) bool { return len(cycles[i]) > len(cycles[j]) })
+	return cycles
+}
+
+// edgesWithin returns the references between the given nodes.
+func (g *graph) edgesWithin(ids []nodeId) [][2]nodeId {
+	in := make(map[nodeId]struct{}, len(ids))
+	for _, id := range ids {
+		in[id] = struct{}{}
+	}
+
+	var edges [][2]nodeId
+	for _, id := range ids {
+		n, ok := g.nodes[id]
+		if !ok {
+			continue
+		}
+		for _, ref := range n.refsSorted() {
+			if _, ok := in[ref]; ok {
+				edges = append(edges, [2]nodeId{id, ref})
+			}
+		}
+	}
+	return edges
+}
+
+// layers condenses the strongly connected components and arranges them in
+// dependency order, leaves first.  Components in layer 0 reference nothing
+// (of the included types) outside themselves, and every other component is
+// in the layer after the highest layer of anything it references.
+func (g *graph) layers(include func(nodeId) bool) [][][]nodeId {
+	components := g.stronglyConnected(include)
+
+	componentOf := make(map[nodeId]int)
+	for i, component := range components {
+		for _, id := range component {
+			componentOf[id] = i
+		}
+	}
+
+	// Components are in reverse topological order, so everything a
+	// component references already has its layer assigned.
+	layerOf := make([]int, len(components))
+	var layers [][][]nodeId
+	for i, component := range components {
+		layer := 0
+		for _, id := range component {
+			n, ok := g.nodes[id]
+			if !ok {
+				continue
+			}
+			for ref := range n.Refs {
+				c, ok := componentOf[ref]
+				if ok && c != i {
+					layer = max(layer, layerOf[c]+1)
+				}
+			}
+		}
+		layerOf[i] = layer
+		for len(layers) <= layer {
+			layers = append(layers, nil)
+		}
+		layers[layer] = append(layers[layer], component)
+	}
+
+	for _, layer := range layers {
+		sort.Slice(layer, func(i, j int) bool { return layer[i][0].id() < layer[j][0].id() })
+	}
+
+	return layers
+}
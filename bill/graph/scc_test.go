@@ -0,0 +1,63 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStronglyConnected(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("a")},
+		[2]nodeId{method("c"), method("d")},
+		[2]nodeId{method("d"), newNodeId("t", ntTable)},
+		[2]nodeId{method("d"), method("missing")},
+		[2]nodeId{method("e"), method("e")},
+	)
+
+	methodsOnly := func(id nodeId) bool { return id.Type == ntMethod }
+
+	// Leaves first
+	assert.Equal([][]nodeId{
+		{method("d")},
+		{method("a"), method("b"), method("c")},
+		{method("e")},
+	}, g.stronglyConnected(methodsOnly))
+
+	assert.Equal([][]nodeId{
+		{method("a"), method("b"), method("c")},
+		{method("e")},
+	}, g.cycles(methodsOnly))
+
+	assert.Equal([][2]nodeId{
+		{method("a"), method("b")},
+		{method("b"), method("c")},
+		{method("c"), method("a")},
+	}, g.edgesWithin([]nodeId{method("a"), method("b"), method("c")}))
+}
+
+func TestLayers(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("a")},
+		[2]nodeId{method("b"), newNodeId("t", ntTable)},
+		[2]nodeId{method("c"), method("a")},
+		[2]nodeId{method("c"), newNodeId("t", ntTable)},
+		[2]nodeId{method("c"), method("missing")},
+	)
+
+	include, err := nodeTypesFilter(nil)
+	assert.NoError(err)
+
+	assert.Equal([][][]nodeId{
+		{{newNodeId("t", ntTable)}},
+		{{method("a"), method("b")}},
+		{{method("c")}},
+	}, g.layers(include))
+}
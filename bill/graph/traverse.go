@@ -0,0 +1,419 @@
+package graph
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// direction is the way references are followed when traversing the graph.
+type direction int
+
+const (
+	// down follows references from a node to the nodes it references
+	down direction = iota
+	// up follows references from a node to the nodes that reference it
+	up
+)
+
+func (d direction) String() string {
+	switch d {
+	case down:
+		return "down"
+	case up:
+		return "up"
+	default:
+		return "unknown"
+	}
+}
+
+var allNodeTypes = []nodeType{
+	ntExport,
+	ntField,
+	ntForm,
+	ntImport,
+	ntIndex,
+	ntMethod,
+	ntPpl,
+	ntProcess,
+	ntPubProc,
+	ntQuery,
+	ntReport,
+	ntTable,
+	ntWorkArea,
+}
+
+func parseNodeType(s string) (nodeType, error) {
+	for _, nt := range allNodeTypes {
+		if strings.EqualFold(s, nt.String()) {
+			return nt, nil
+		}
+	}
+	return "", fmt.Errorf("unknown node type : '%s'", s)
+}
+
+// typeFilter restricts which nodes are shown and traversed.  Excluded node
+// types are neither shown nor traversed through (in the same way as the
+// `NONE(n IN nodes(path) WHERE ...)` neo queries in the README). If any
+// types are included, only those are shown, but other types are still
+// traversed through.
+type typeFilter struct {
+	include map[nodeType]struct{}
+	exclude map[nodeType]struct{}
+}
+
+func newTypeFilter(include []string, exclude []string) (typeFilter, error) {
+	f := typeFilter{
+		include: make(map[nodeType]struct{}),
+		exclude: make(map[nodeType]struct{}),
+	}
+	for _, s := range include {
+		nt, err := parseNodeType(s)
+		if err != nil {
+			return f, err
+		}
+		f.include[nt] = struct{}{}
+	}
+	for _, s := range exclude {
+		nt, err := parseNodeType(s)
+		if err != nil {
+			return f, err
+		}
+		f.exclude[nt] = struct{}{}
+	}
+	return f, nil
+}
+
+// onlyTypesFilter returns a filter that only shows and traverses the given
+// node types, or all types if none are given.
+func onlyTypesFilter(types []string) (typeFilter, error) {
+	wanted := make(map[nodeType]struct{})
+	for _, s := range types {
+		nt, err := parseNodeType(s)
+		if err != nil {
+			return typeFilter{}, err
+		}
+		wanted[nt] = struct{}{}
+	}
+
+	f := typeFilter{exclude: make(map[nodeType]struct{})}
+	if len(wanted) == 0 {
+		return f, nil
+	}
+	for _, nt := range allNodeTypes {
+		if _, ok := wanted[nt]; !ok {
+			f.exclude[nt] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+// traverses reports whether nodes of this type may be visited.
+func (f typeFilter) traverses(nt nodeType) bool {
+	_, excluded := f.exclude[nt]
+	return !excluded
+}
+
+// shows reports whether nodes of this type should be output.
+func (f typeFilter) shows(nt nodeType) bool {
+	if !f.traverses(nt) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	_, included := f.include[nt]
+	return included
+}
+
+// findNode finds a node by name and optionally type.  Nodes that are
+// referenced but not defined anywhere are also found.  If no type is
+// given, the name must be unambiguous.
+func (g *graph) findNode(name string, nt string) (nodeId, error) {
+	name = strings.ToLower(name)
+
+	var wanted nodeType
+	if nt != "" {
+		var err error
+		if wanted, err = parseNodeType(nt); err != nil {
+			return nodeId{}, err
+		}
+	}
+
+	found := make(map[nodeId]struct{})
+	check := func(id nodeId) {
+		if id.Name == name && (wanted == "" || id.Type == wanted) {
+			found[id] = struct{}{}
+		}
+	}
+	for id, n := range g.nodes {
+		check(id)
+		for ref := range n.Refs {
+			check(ref)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nodeId{}, fmt.Errorf("no node found with name '%s'", name)
+	case 1:
+		for id := range found {
+			return id, nil
+		}
+	}
+
+	var types []string
+	for id := range found {
+		types = append(types, id.Type.String())
+	}
+	sort.Strings(types)
+	return nodeId{}, fmt.Errorf("name '%s' is ambiguous, specify one of the types : %s", name, strings.Join(types, ", "))
+}
+
+// label returns the display label for the node, falling back to the node
+// name for nodes that are referenced but missing.
+func (g *graph) label(id nodeId) string {
+	if n, ok := g.nodes[id]; ok && n.Label != "" {
+		return n.Label
+	}
+	return id.Name
+}
+
+// referencedBy returns the reverse of the references in the graph, that is,
+// a map of node to the (sorted) nodes that reference it.
+func (g *graph) referencedBy() map[nodeId][]nodeId {
+	rev := make(map[nodeId][]nodeId)
+	for _, n := range g.nodesSorted() {
+		for _, ref := range n.refsSorted() {
+			rev[ref] = append(rev[ref], n.nodeId)
+		}
+	}
+	return rev
+}
+
+// neighbours returns a function giving the (sorted) adjacent nodes in the
+// given direction.
+func (g *graph) neighbours(dir direction) func(nodeId) []nodeId {
+	if dir == up {
+		rev := g.referencedBy()
+		return func(id nodeId) []nodeId {
+			return rev[id]
+		}
+	}
+	return func(id nodeId) []nodeId {
+		n, ok := g.nodes[id]
+		if !ok {
+			return nil
+		}
+		return n.refsSorted()
+	}
+}
+
+// reach performs a breadth first traversal from the start nodes in the given
+// direction, returning every node reached with its distance from the start.
+// The start nodes themselves are not included unless they are reachable from
+// one another.  A maxDepth of zero means unlimited.
+func (g *graph) reach(start []nodeId, dir direction, maxDepth int, filter typeFilter) map[nodeId]int {
+	next := g.neighbours(dir)
+
+	reached := make(map[nodeId]int)
+	visited := make(map[nodeId]struct{})
+	for _, s := range start {
+		visited[s] = struct{}{}
+	}
+
+	frontier := start
+	for depth := 1; len(frontier) != 0 && (maxDepth == 0 || depth <= maxDepth); depth++ {
+		var nextFrontier []nodeId
+		for _, id := range frontier {
+			for _, n := range next(id) {
+				if !filter.traverses(n.Type) {
+					continue
+				}
+				if _, ok := reached[n]; !ok {
+					reached[n] = depth
+				}
+				if _, ok := visited[n]; ok {
+					continue
+				}
+				visited[n] = struct{}{}
+				nextFrontier = append(nextFrontier, n)
+			}
+		}
+		frontier = nextFrontier
+	}
+
+	return reached
+}
+
+// sortedByDepth returns the nodes sorted by depth, then type and name.
+func sortedByDepth(depths map[nodeId]int) []nodeId {
+	ids := make([]nodeId, 0, len(depths))
+	for id := range depths {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if depths[ids[i]] != depths[ids[j]] {
+			return depths[ids[i]] < depths[ids[j]]
+		}
+		return ids[i].id() < ids[j].id()
+	})
+	return ids
+}
+
+// markLive marks every entry point, and everything transitively referenced
+// from an entry point, as live.  Unlike used, which only reflects direct
+// references, anything referenced solely by dead code is not live.
+func (g *graph) markLive() {
+	start := g.entryPointsSorted()
+
+	g.live = make(map[nodeId]struct{})
+	for _, id := range start {
+		g.live[id] = struct{}{}
+	}
+	for id := range g.reach(start, down, 0, typeFilter{}) {
+		g.live[id] = struct{}{}
+	}
+}
+
+func (g *graph) entryPointsSorted() []nodeId {
+	ids := make([]nodeId, 0, len(g.entryPoints))
+	for id := range g.entryPoints {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].id() < ids[j].id() })
+	return ids
+}
+
+// shortestPaths finds the shortest paths from any of the start nodes to the
+// target, following references in the given direction.  At most limit paths
+// are returned (zero for no limit), and none if the target is unreachable.
+// Each path includes both the start node and the target.
+func (g *graph) shortestPaths(start []nodeId, target nodeId, dir direction, filter typeFilter, limit int) [][]nodeId {
+	next := g.neighbours(dir)
+
+	// Breadth first, recording every predecessor on a shortest path
+	dist := make(map[nodeId]int)
+	preds := make(map[nodeId][]nodeId)
+	for _, s := range start {
+		dist[s] = 0
+	}
+
+	frontier := start
+	for len(frontier) != 0 {
+		if _, found := dist[target]; found {
+			break
+		}
+		var nextFrontier []nodeId
+		for _, id := range frontier {
+			for _, n := range next(id) {
+				if !filter.traverses(n.Type) {
+					continue
+				}
+				d, seen := dist[n]
+				if !seen {
+					dist[n] = dist[id] + 1
+					nextFrontier = append(nextFrontier, n)
+				} else if d != dist[id]+1 {
+					continue
+				}
+				preds[n] = append(preds[n], id)
+			}
+		}
+		frontier = nextFrontier
+	}
+
+	if _, found := dist[target]; !found {
+		return nil
+	}
+
+	// Walk back through the predecessors to enumerate the paths
+	var paths [][]nodeId
+	var walk func(id nodeId, suffix []nodeId)
+	walk = func(id nodeId, suffix []nodeId) {
+		if limit != 0 && len(paths) >= limit {
+			return
+		}
+		path := append([]nodeId{id}, suffix...)
+		if dist[id] == 0 {
+			paths = append(paths, path)
+			return
+		}
+		for _, p := range preds[id] {
+			walk(p, path)
+		}
+	}
+	walk(target, nil)
+
+	return paths
+}
+
+// formatPath formats a path as a chain of names and types.
+func (g *graph) formatPath(path []nodeId) string {
+	var sb strings.Builder
+	for i, id := range path {
+		if i != 0 {
+			sb.WriteString(" -> ")
+		}
+		fmt.Fprintf(&sb, "%s (%s)", g.label(id), id.Type)
+	}
+	return sb.String()
+}
+
+// simplePaths finds every path without repeated nodes from start to target,
+// of at most maxLength references, following references downwards.  At most
+// limit paths are returned (zero for no limit).  Paths are returned shortest
+// first.
+func (g *graph) simplePaths(start nodeId, target nodeId, maxLength int, filter typeFilter, limit int) [][]nodeId {
+	next := g.neighbours(down)
+
+	// The shortest distance from each node to the target, so the search
+	// can skip nodes that cannot reach it in the length remaining
+	toTarget := g.reach([]nodeId{target}, up, maxLength, filter)
+	toTarget[target] = 0
+
+	var paths [][]nodeId
+	full := func() bool { return limit != 0 && len(paths) >= limit }
+
+	onPath := map[nodeId]bool{start: true}
+	path := []nodeId{start}
+
+	// Search for paths of each length in turn (iterative deepening), so
+	// the shortest are found first and the search can stop at the limit,
+	// rather than enumerating every path, which is exponential on dense
+	// graphs.
+	var walk func(id nodeId, length int)
+	walk = func(id nodeId, length int) {
+		if id == target {
+			if len(path)-1 == length {
+				paths = append(paths, slices.Clone(path))
+			}
+			return
+		}
+		if len(path)-1 >= length {
+			return
+		}
+		for _, n := range next(id) {
+			if full() {
+				return
+			}
+			if onPath[n] || !filter.traverses(n.Type) {
+				continue
+			}
+			if d, ok := toTarget[n]; !ok || len(path)+d > length {
+				continue
+			}
+			onPath[n] = true
+			path = append(path, n)
+			walk(n, length)
+			path = path[:len(path)-1]
+			onPath[n] = false
+		}
+	}
+	for length := 0; length <= maxLength && !full(); length++ {
+		walk(start, length)
+	}
+
+	return paths
+}
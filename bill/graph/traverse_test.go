@@ -0,0 +1,267 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGraph builds a graph from "from -> to" pairs of node ids.  Every
+// "from" node is added to the graph, "to" nodes are only added implicitly
+// (as addNode does for tables etc) or if they are also a "from".
+func testGraph(refs ...[2]nodeId) *graph {
+	g := newGraph()
+	for _, ref := range refs {
+		n, ok := g.nodes[ref[0]]
+		if !ok {
+			n = newNode()
+			n.nodeId = ref[0]
+			n.Label = ref[0].Name
+			g.nodes[ref[0]] = n
+		}
+		n.Refs[ref[1]] = struct{}{}
+	}
+	for _, n := range g.nodes {
+		g.addNode(n)
+	}
+	return g
+}
+
+func method(name string) nodeId {
+	return newNodeId(name, ntMethod)
+}
+
+func TestReachDown(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("b"), newNodeId("f", ntField)},
+		[2]nodeId{method("c"), method("a")},
+	)
+
+	noFilter, err := newTypeFilter(nil, nil)
+	assert.NoError(err)
+
+	assert.Equal(map[nodeId]int{
+		method("b"):             1,
+		method("c"):             2,
+		newNodeId("f", ntField): 2,
+		method("a"):             3,
+	}, g.reach([]nodeId{method("a")}, down, 0, noFilter))
+
+	assert.Equal(map[nodeId]int{
+		method("b"): 1,
+	}, g.reach([]nodeId{method("a")}, down, 1, noFilter))
+
+	noFields, err := newTypeFilter(nil, []string{"field"})
+	assert.NoError(err)
+
+	assert.Equal(map[nodeId]int{
+		method("b"): 1,
+		method("c"): 2,
+		method("a"): 3,
+	}, g.reach([]nodeId{method("a")}, down, 0, noFields))
+}
+
+func TestReachUp(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("b"), method("missing")},
+		[2]nodeId{method("c"), method("b")},
+	)
+
+	noFilter, err := newTypeFilter(nil, nil)
+	assert.NoError(err)
+
+	assert.Equal(map[nodeId]int{
+		method("b"): 1,
+		method("a"): 2,
+		method("c"): 2,
+	}, g.reach([]nodeId{method("missing")}, up, 0, noFilter))
+}
+
+func TestFindNode(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), newNodeId("a", ntTable)},
+		[2]nodeId{method("a"), method("missing")},
+	)
+
+	_, err := g.findNode("A", "")
+	assert.Error(err)
+
+	id, err := g.findNode("A", "table")
+	assert.NoError(err)
+	assert.Equal(newNodeId("a", ntTable), id)
+
+	id, err = g.findNode("missing", "")
+	assert.NoError(err)
+	assert.Equal(method("missing"), id)
+
+	_, err = g.findNode("a", "nonsense")
+	assert.Error(err)
+}
+
+func TestMarkLive(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{newNodeId("entry", ntPubProc), method("a")},
+		[2]nodeId{method("a"), newNodeId("t", ntTable)},
+		[2]nodeId{method("dead"), method("onlycalledbydead")},
+		[2]nodeId{method("dead"), newNodeId("t", ntTable)},
+		[2]nodeId{method("onlycalledbydead"), newNodeId("t", ntTable)},
+	)
+	g.entryPoints[newNodeId("entry", ntPubProc)] = "test"
+
+	g.markLive()
+
+	assert.Equal(map[nodeId]struct{}{
+		newNodeId("entry", ntPubProc): {},
+		method("a"):                   {},
+		newNodeId("t", ntTable):       {},
+	}, g.live)
+
+	var unusedMethods []string
+	for _, r := range g.deadCode(true) {
+		if r.name == "unused_methods" {
+			for _, row := range r.rows {
+				unusedMethods = append(unusedMethods, row[0])
+			}
+		}
+	}
+	assert.Equal([]string{"dead", "onlycalledbydead"}, unusedMethods)
+}
+
+func TestShortestPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("a"), method("c")},
+		[2]nodeId{method("a"), method("long")},
+		[2]nodeId{method("b"), method("d")},
+		[2]nodeId{method("c"), method("d")},
+		[2]nodeId{method("long"), method("longer")},
+		[2]nodeId{method("longer"), method("d")},
+	)
+
+	assert.Equal([][]nodeId{
+		{method("a"), method("b"), method("d")},
+		{method("a"), method("c"), method("d")},
+	}, g.shortestPaths([]nodeId{method("a")}, method("d"), down, typeFilter{}, 0))
+
+	assert.Len(g.shortestPaths([]nodeId{method("a")}, method("d"), down, typeFilter{}, 1), 1)
+
+	assert.Equal([][]nodeId{
+		{method("d"), method("b"), method("a")},
+		{method("d"), method("c"), method("a")},
+	}, g.shortestPaths([]nodeId{method("d")}, method("a"), up, typeFilter{}, 0))
+
+	assert.Nil(g.shortestPaths([]nodeId{method("d")}, method("a"), down, typeFilter{}, 0))
+}
+
+func TestSimplePaths(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("a"), method("d")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("a")},
+		[2]nodeId{method("c"), method("d")},
+	)
+
+	assert.Equal([][]nodeId{
+		{method("a"), method("d")},
+		{method("a"), method("b"), method("c"), method("d")},
+	}, g.simplePaths(method("a"), method("d"), 3, typeFilter{}, 0))
+
+	assert.Equal([][]nodeId{
+		{method("a"), method("d")},
+	}, g.simplePaths(method("a"), method("d"), 2, typeFilter{}, 0))
+
+	assert.Equal([][]nodeId{
+		{method("a"), method("d")},
+	}, g.simplePaths(method("a"), method("d"), 3, typeFilter{}, 1))
+}
+
+func TestSimplePathsLimitOnDenseGraph(t *testing.T) {
+	assert := assert.New(t)
+
+	// 15 layers of 8 methods, each calling every method in the next layer,
+	// so there are 8^14 paths from top to bottom
+	var refs [][2]nodeId
+	layer := func(l int, i int) nodeId { return method(fmt.Sprintf("l%02d_%d", l, i)) }
+	for l := 0; l < 14; l++ {
+		for i := 0; i < 8; i++ {
+			for j := 0; j < 8; j++ {
+				refs = append(refs, [2]nodeId{layer(l, i), layer(l+1, j)})
+			}
+		}
+	}
+	g := testGraph(refs...)
+
+	paths := g.simplePaths(layer(0, 0), layer(14, 0), 20, typeFilter{}, 3)
+	assert.Len(paths, 3)
+	for _, p := range paths {
+		assert.Len(p, 15)
+	}
+}
+
+func TestWriteCallTree(t *testing.T) {
+	assert := assert.New(t)
+
+	g := testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("a"), method("c")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("a")},
+		[2]nodeId{method("c"), method("zz")},
+		[2]nodeId{method("c"), newNodeId("t", ntTable)},
+	)
+
+	filter, err := onlyTypesFilter(callTypes)
+	assert.NoError(err)
+
+	var sb strings.Builder
+	g.writeCallTree(&sb, method("a"), 0, filter, false)
+	assert.Equal(`a (method)
+├── b (method)
+│   └── c (method)
+│       ├── a (method) [cycle]
+│       └── zz (method) [missing]
+└── c (method) [see above]
+`, sb.String())
+
+	sb.Reset()
+	g.writeCallTree(&sb, method("a"), 1, filter, false)
+	assert.Equal(`a (method)
+├── b (method)
+└── c (method)
+`, sb.String())
+
+	// c is first reached at the depth limit, so its calls are shown when it
+	// is reached again higher up
+	g = testGraph(
+		[2]nodeId{method("a"), method("b")},
+		[2]nodeId{method("a"), method("c")},
+		[2]nodeId{method("b"), method("c")},
+		[2]nodeId{method("c"), method("d")},
+	)
+	sb.Reset()
+	g.writeCallTree(&sb, method("a"), 2, filter, false)
+	assert.Equal(`a (method)
+├── b (method)
+│   └── c (method)
+└── c (method)
+    └── d (method) [missing]
+`, sb.String())
+}
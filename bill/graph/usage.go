@@ -0,0 +1,316 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// parseSince parses the start of a usage window, either as an absolute date
+// (2006-01-02) or relative to now as a number of days, weeks, months or
+// years, e.g. 90d, 6w, 18m or 2y.  An empty string means all time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(dateFormat, s); err == nil {
+		return t, nil
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid usage window '%s', expected a date (YYYY-MM-DD) or a period such as 90d, 6w, 18m or 2y", s)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch s[len(s)-1] {
+	case 'd':
+		return today.AddDate(0, 0, -n), nil
+	case 'w':
+		return today.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return addMonths(today, -n), nil
+	case 'y':
+		return addMonths(today, -12*n), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid usage window '%s', expected a date (YYYY-MM-DD) or a period such as 90d, 6w, 18m or 2y", s)
+	}
+}
+
+// addMonths adds n months to a date, clamping the day to the end of the
+// target month, so that a month before 31 March is 28 (or 29) February, not
+// 3 March as with time.AddDate.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(t.Day(), lastDay), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// usage is how much a module was used in production, according to ModuDet.
+type usage struct {
+	calls int
+	first time.Time
+	last  time.Time
+}
+
+func (u *usage) add(t time.Time) {
+	if u.calls == 0 || t.Before(u.first) {
+		u.first = t
+	}
+	if u.calls == 0 || t.After(u.last) {
+		u.last = t
+	}
+	u.calls++
+}
+
+func (u *usage) merge(other *usage) {
+	if other.calls == 0 {
+		return
+	}
+	if u.calls == 0 || other.first.Before(u.first) {
+		u.first = other.first
+	}
+	if u.calls == 0 || other.last.After(u.last) {
+		u.last = other.last
+	}
+	u.calls += other.calls
+}
+
+func (u *usage) properties() properties {
+	return properties{
+		"calls":      u.calls,
+		"first_used": u.first.Format(dateFormat),
+		"last_used":  u.last.Format(dateFormat),
+	}
+}
+
+// Ways to handle ModuDet logic ids that are not in ModuleS
+const (
+	unknownLogicError  = "error"
+	unknownLogicWarn   = "warn"
+	unknownLogicIgnore = "ignore"
+)
+
+// applyModules marks modules called since the given time, according to
+// ModuDet, as used entry points, with their call counts and first and last
+// use as properties.  The calls between modules are added as observed calls.
+func (g *graph) applyModules(modulesCsv string, modudetCsv string, since time.Time, unknownLogic string) error {
+	switch {
+	case modulesCsv == "" && modudetCsv == "":
+		return nil
+	case modulesCsv == "" || modudetCsv == "":
+		return errors.New("module CSV files must both be provided")
+	}
+
+	modNames, err := readModulesCsv(modulesCsv)
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]*usage)
+	callers := make(map[[2]string]int) // by caller name and callee logic id
+	err = eachModudetCall(modudetCsv, since, time.Time{}, func(call modudetCall) {
+		u, ok := used[call.logic]
+		if !ok {
+			u = &usage{}
+			used[call.logic] = u
+		}
+		u.add(call.date)
+		callers[[2]string{call.caller, call.logic}]++
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := g.applyUsage(modNames, used, unknownLogic); err != nil {
+		return err
+	}
+
+	// Unknown logic ids have already been reported by applyUsage
+	var calls []observedCall
+	for k, count := range callers {
+		if callee, ok := modNames[k[1]]; ok {
+			calls = append(calls, observedCall{caller: k[0], callee: callee, count: count})
+		}
+	}
+	if unmatched := g.applyObservedCalls(calls); unmatched != 0 {
+		log.Printf("%d observed calls not matched to modules in the source - skipping", unmatched)
+	}
+
+	return nil
+}
+
+// applyUsage applies usage by logic id to the modules with those logic ids.
+func (g *graph) applyUsage(modNames map[string]string, used map[string]*usage, unknownLogic string) error {
+	byName, err := usageByName(modNames, used, unknownLogic)
+	if err != nil {
+		return err
+	}
+
+	g.applyUsageByName(byName)
+
+	return nil
+}
+
+// usageByName converts usage by logic id to usage by full module name (as in
+// ModuleS), handling logic ids that are not in ModuleS as unknownLogic says.
+func usageByName(modNames map[string]string, used map[string]*usage, unknownLogic string) (map[string]*usage, error) {
+	switch unknownLogic {
+	case unknownLogicError, unknownLogicWarn, unknownLogicIgnore:
+	default:
+		return nil, fmt.Errorf("unknown handling for unknown logic ids : '%s'", unknownLogic)
+	}
+
+	logics := make([]string, 0, len(used))
+	for logic := range used {
+		logics = append(logics, logic)
+	}
+	sort.Strings(logics)
+
+	byName := make(map[string]*usage)
+	for _, logic := range logics {
+		name, ok := modNames[logic]
+		if !ok {
+			switch unknownLogic {
+			case unknownLogicError:
+				return nil, fmt.Errorf("unknown module with logic id %s", logic)
+			case unknownLogicWarn:
+				log.Printf("unknown module with logic id %s - skipping", logic)
+			}
+			continue
+		}
+
+		u, ok := byName[name]
+		if !ok {
+			u = &usage{}
+			byName[name] = u
+		}
+		u.merge(used[logic])
+	}
+	return byName, nil
+}
+
+// applyUsageByName applies usage by full module name (as in ModuleS) to
+// those modules.
+func (g *graph) applyUsageByName(used map[string]*usage) {
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resolve := g.moduleNameResolver()
+
+	// Truncated and full names may resolve to the same module
+	byModule := make(map[nodeId]*usage)
+	for _, name := range names {
+		id, err := resolve(name)
+		if err != nil {
+			log.Printf("%v - skipping", err)
+			continue
+		}
+		u, ok := byModule[id]
+		if !ok {
+			u = &usage{}
+			byModule[id] = u
+		}
+		u.merge(used[name])
+	}
+
+	for id, u := range byModule {
+		g.used[id] = struct{}{}
+		g.entryPoints[id] = reasonModuDet
+		g.setProperties(id, u.properties())
+	}
+}
+
+// moduleExtensions are the extensions of the full names of modules, as
+// used in ModuleS.
+var moduleExtensions = map[nodeType]string{
+	ntExport: "exp",
+	ntForm:   "frm",
+	ntImport: "imp",
+	ntMethod: "jcl",
+	ntQuery:  "qry",
+	ntReport: "rep",
+}
+
+// moduleNameResolver returns a function that finds the module for a full
+// module name from ModuleS, e.g. "NRG_SWEEP2.jcl".  Some names in ModuleS
+// are truncated, e.g. "NRG_SWEE", and these are resolved if they are the
+// prefix of the full name of exactly one module in the source.
+func (g *graph) moduleNameResolver() func(name string) (nodeId, error) {
+	var fullNames []string
+	modules := make(map[string]nodeId)
+	for id := range g.nodes {
+		if ext, ok := moduleExtensions[id.Type]; ok {
+			full := id.Name + "." + ext
+			fullNames = append(fullNames, full)
+			modules[full] = id
+		}
+	}
+	sort.Strings(fullNames)
+
+	return func(name string) (nodeId, error) {
+		if strings.Contains(name, ".") {
+			if id, _ := idAndLabelFromFullName(name); id.Type != "UNKNOWN" {
+				return id, nil
+			}
+		}
+
+		prefix := strings.ToLower(name)
+		i := sort.SearchStrings(fullNames, prefix)
+		var matches []string
+		for ; i < len(fullNames) && strings.HasPrefix(fullNames[i], prefix); i++ {
+			matches = append(matches, fullNames[i])
+		}
+
+		switch len(matches) {
+		case 1:
+			return modules[matches[0]], nil
+		case 0:
+			return nodeId{}, fmt.Errorf("truncated module name '%s' matches no modules", name)
+		default:
+			return nodeId{}, fmt.Errorf("truncated module name '%s' is ambiguous, matching %s", name, strings.Join(matches, ", "))
+		}
+	}
+}
+
+// ModuleCallCounts returns the number of calls to each module from from to
+// to, inclusive, by full module name (as in ModuleS), from ModuleS and
+// ModuDet CSV exports.  A zero to means no limit.  ModuDet logic ids that are
+// not in ModuleS are handled as unknownLogic says [error|warn|ignore], by
+// default warn.
+func ModuleCallCounts(modulesCsv string, modudetCsv string, from time.Time, to time.Time, unknownLogic string) (map[string]int, error) {
+	if unknownLogic == "" {
+		unknownLogic = unknownLogicWarn
+	}
+
+	modNames, err := readModulesCsv(modulesCsv)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := readModudetCsv(modudetCsv, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byName, err := usageByName(modNames, used, unknownLogic)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for name, u := range byName {
+		counts[name] = u.calls
+	}
+	return counts, nil
+}
@@ -0,0 +1,69 @@
+package graph
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSince(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2025, 3, 31, 15, 4, 5, 0, time.UTC)
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	for s, expected := range map[string]time.Time{
+		"":           {},
+		"2024-01-01": date(2024, 1, 1),
+		"90d":        date(2024, 12, 31),
+		"2w":         date(2025, 3, 17),
+		"18m":        date(2023, 9, 30), // clamped to the end of September
+		"1m":         date(2025, 2, 28),
+		"13m":        date(2024, 2, 29),
+		"1y":         date(2024, 3, 31),
+	} {
+		since, err := parseSince(s, now)
+		assert.NoError(err, s)
+		assert.Equal(expected, since, s)
+	}
+
+	// From a leap day
+	since, err := parseSince("1y", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC))
+	assert.NoError(err)
+	assert.Equal(date(2023, 2, 28), since)
+
+	for _, s := range []string{"m", "18x", "-1d", "2024-13-01"} {
+		_, err := parseSince(s, now)
+		assert.Error(err, s)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	var u usage
+	u.add(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	u.add(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	u.add(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(properties{
+		"calls":      3,
+		"first_used": "2024-02-01",
+		"last_used":  "2024-05-01",
+	}, u.properties())
+}
+
+func TestGraphSourceUsage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(GraphSource{}.hasUsage())
+	assert.True(GraphSource{ModulesCsv: "m.csv", ModudetCsv: "d.csv"}.hasUsage())
+	assert.True(GraphSource{UsageDsn: "postgres://localhost/bill"}.hasUsage())
+
+	// Checked before connecting
+	_, err := buildGraph(GraphSource{SourceRoot: t.TempDir(), UsageDsn: "postgres://localhost/bill", UnknownLogicIds: unknownLogicError})
+	assert.ErrorContains(err, "only applies to usage read from CSV files")
+}
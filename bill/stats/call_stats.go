@@ -2,84 +2,192 @@ package stats
 
 import (
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/olekukonko/tablewriter"
+	"github.com/utilitywarehouse/billsourcery/bill/graph"
 )
 
-func CallStatsTable(sourceRoot string, dsn string) error {
-	return callStatsTable(sourceRoot, dsn)
+// CallStatsOptions say where module calls are read from, which calls are
+// counted, and how the results are written.
+type CallStatsOptions struct {
+	// Dsn is the Bill Postgres mirror to read calls from, or else ...
+	Dsn string
+	// ... ModulesCsv and ModudetCsv are ModuleS and ModuDet CSV exports
+	ModulesCsv string
+	ModudetCsv string
+	// UnknownLogicIds is how to handle ModuDet logic ids that are not in
+	// ModuleS [error|warn|ignore], for CSV files only
+	UnknownLogicIds string
+	// From and To limit the calls counted to those dates, inclusive.  Zero
+	// values mean no limit.
+	From time.Time
+	To   time.Time
+	// Format is the output format [table|csv|json|markdown]
+	Format string
 }
 
-func callStatsTable(sourceRoot string, dsn string) error {
+func CallStatsTable(sourceRoot string, opts CallStatsOptions) error {
+	return callStatsTable(sourceRoot, opts)
+}
+
+func callStatsTable(sourceRoot string, opts CallStatsOptions) error {
 	all := &allModules{}
 	if err := walkSource(sourceRoot, all); err != nil {
 		return err
 	}
 
+	var byName map[string]int
+	var err error
+	switch {
+	case opts.Dsn != "" && (opts.ModulesCsv != "" || opts.ModudetCsv != ""):
+		return errors.New("calls must come from either a DSN or CSV files, not both")
+	case opts.Dsn != "" && opts.UnknownLogicIds != "":
+		return errors.New("unknown logic id handling only applies to calls read from CSV files, not a DSN")
+	case opts.Dsn != "":
+		byName, err = callCountsPostgres(opts.Dsn, opts.From, opts.To)
+	case opts.ModulesCsv != "" && opts.ModudetCsv != "":
+		byName, err = graph.ModuleCallCounts(opts.ModulesCsv, opts.ModudetCsv, opts.From, opts.To, opts.UnknownLogicIds)
+	default:
+		return errors.New("either a DSN or both module CSV files must be provided")
+	}
+	if err != nil {
+		return err
+	}
+
 	// map of module name to call count
 	counts := make(map[module]int)
+	for callto, count := range byName {
+		spl := strings.Split(strings.ToLower(callto), ".")
+		if len(spl) < 2 {
+			// Truncated, so the type is unknown
+			continue
+		}
+		mt, ok := lookupModExt(spl[1])
+		if !ok {
+			continue
+		}
+		mod := module{moduleName: spl[0], moduleType: mt}
+		counts[mod] = counts[mod] + count
+	}
 
+	var results []callStatsEntry
+
+	for _, mod := range all.modules {
+		results = append(results, callStatsEntry{mod.moduleName, mod.moduleType, counts[mod]})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].ModuleName < results[j].ModuleName })
+	sort.SliceStable(results, func(i, j int) bool { return results[i].CallCount < results[j].CallCount })
+
+	return writeCallStats(os.Stdout, results, opts.Format)
+}
+
+// callCountsPostgres returns the number of Client/Server calls to each module
+// by full module name, from the Bill Postgres mirror.
+func callCountsPostgres(dsn string, from time.Time, to time.Time) (map[string]int, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer db.Close()
 
 	q := `select
 		modudetlastparam caller, modname callto, count(*)
 		from equinox.modules mods
 		inner join equinox.modudet dets on mods.equinox_lrn = dets.equinox_prn and modudetsofttype='Client/Server'
+		where dets.modudetdate >= $1 and ($2::date is null or dets.modudetdate <= $2::date)
 		group by caller, callto
 		order by count(*) desc
 		;
 	`
 
-	rows, err := db.Query(q)
+	var until sql.NullTime
+	if !to.IsZero() {
+		until = sql.NullTime{Time: to, Valid: true}
+	}
+
+	rows, err := db.Query(q, from, until)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer rows.Close()
+
+	counts := make(map[string]int)
 	for rows.Next() {
 		var caller string
 		var callto string
 		var count int
 		if err := rows.Scan(&caller, &callto, &count); err != nil {
-			return err
+			return nil, err
 		}
-		spl := strings.Split(strings.ToLower(callto), ".")
-		mod := module{moduleName: spl[0], moduleType: mapModExt(spl[1])}
-		counts[mod] = counts[mod] + count
+		counts[callto] += count
 	}
 	if err := rows.Err(); err != nil {
-		return err
+		return nil, err
 	}
+	return counts, nil
+}
 
-	type tableEntry struct {
-		ModuleName string
-		ModuleType moduleType
-		CallCount  int
-	}
+type callStatsEntry struct {
+	ModuleName string     `json:"module"`
+	ModuleType moduleType `json:"type"`
+	CallCount  int        `json:"call_count"`
+}
 
-	var results []tableEntry
+var callStatsHeader = []string{"module", "type", "call count"}
 
-	for _, mod := range all.modules {
-		results = append(results, tableEntry{mod.moduleName, mod.moduleType, counts[mod]})
-	}
-
-	sort.Slice(results, func(i, j int) bool { return results[i].ModuleName < results[j].ModuleName })
-	sort.SliceStable(results, func(i, j int) bool { return results[i].CallCount < results[j].CallCount })
+func (e callStatsEntry) row() []string {
+	return []string{e.ModuleName, e.ModuleType.String(), strconv.Itoa(e.CallCount)}
+}
 
-	tw := tablewriter.NewWriter(os.Stdout)
-	tw.Header([]string{"module", "type", "call count"})
-	for _, res := range results {
-		tw.Append([]string{res.ModuleName, res.ModuleType.String(), strconv.Itoa(res.CallCount)})
+func writeCallStats(w io.Writer, results []callStatsEntry, format string) error {
+	switch format {
+	case "table", "":
+		tw := tablewriter.NewWriter(w)
+		tw.Header(callStatsHeader)
+		for _, res := range results {
+			tw.Append(res.row())
+		}
+		tw.Render()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(callStatsHeader); err != nil {
+			return err
+		}
+		for _, res := range results {
+			if err := cw.Write(res.row()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		if results == nil {
+			results = []callStatsEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "markdown":
+		fmt.Fprintf(w, "| %s |\n", strings.Join(callStatsHeader, " | "))
+		fmt.Fprintln(w, "| --- | --- | ---: |")
+		for _, res := range results {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(res.row(), " | "))
+		}
+	default:
+		return fmt.Errorf("unknown output format : '%s'", format)
 	}
-	tw.Render()
-
 	return nil
 }
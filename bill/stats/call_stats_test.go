@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCallStats(t *testing.T) {
+	assert := assert.New(t)
+
+	results := []callStatsEntry{
+		{ModuleName: "unused", ModuleType: mtForm, CallCount: 0},
+		{ModuleName: "nrg_sweep2", ModuleType: mtMethod, CallCount: 12},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(writeCallStats(&buf, results, "csv"))
+	assert.Equal("module,type,call count\nunused,form,0\nnrg_sweep2,method,12\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(writeCallStats(&buf, results, "json"))
+	assert.JSONEq(`[
+		{"module": "unused", "type": "form", "call_count": 0},
+		{"module": "nrg_sweep2", "type": "method", "call_count": 12}
+	]`, buf.String())
+
+	// An empty list, not null
+	buf.Reset()
+	assert.NoError(writeCallStats(&buf, nil, "json"))
+	assert.Equal("[]\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(writeCallStats(&buf, results, "markdown"))
+	assert.Equal(`| module | type | call count |
+| --- | --- | ---: |
+| unused | form | 0 |
+| nrg_sweep2 | method | 12 |
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(writeCallStats(&buf, results, "table"))
+	assert.Contains(buf.String(), "│ nrg_sweep2 │ method │ 12         │")
+
+	assert.ErrorContains(writeCallStats(&buf, results, "xml"), "unknown output format : 'xml'")
+}
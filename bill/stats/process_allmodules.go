@@ -90,30 +90,40 @@ func (m *allModules) process(path string) error {
 }
 
 func mapModExt(in string) moduleType {
+	mt, ok := lookupModExt(in)
+	if !ok {
+		panic(fmt.Sprintf("can't map module extension to type : %s\n", in))
+	}
+	return mt
+}
+
+// lookupModExt maps a module extension, which may be truncated, to a module
+// type, or reports false if the extension is not known.
+func lookupModExt(in string) (moduleType, bool) {
 	switch in {
 	case "exp":
-		return mtExport
+		return mtExport, true
 	case "frm":
-		return mtForm
+		return mtForm, true
 	case "fr":
-		return mtForm
+		return mtForm, true
 	case "f":
-		return mtForm
+		return mtForm, true
 	case "imp":
-		return mtImport
+		return mtImport, true
 	case "jcl":
-		return mtMethod
+		return mtMethod, true
 	case "jc":
-		return mtMethod
+		return mtMethod, true
 	case "j":
-		return mtMethod
+		return mtMethod, true
 		//	return mtProcedure
 		//	return mtProcess
 	case "qry":
-		return mtQuery
+		return mtQuery, true
 	case "rep":
-		return mtReport
+		return mtReport, true
 	default:
-		panic(fmt.Sprintf("can't map module extension to type : %s\n", in))
+		return "", false
 	}
 }
@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/utilitywarehouse/billsourcery/bill/graph"
+)
+
+// ReconcileModules compares the modules registered in production (ModuleS,
+// from a CSV export or the Postgres mirror) with the modules in the source
+// tree, and reports any drift between them.
+func ReconcileModules(sourceRoot string, modulesCsv string, dsn string) error {
+	all := &allModules{}
+	if err := walkSource(sourceRoot, all); err != nil {
+		return err
+	}
+
+	var names []string
+	var err error
+	switch {
+	case modulesCsv != "" && dsn != "":
+		return errors.New("modules must come from either a CSV file or a DSN, not both")
+	case modulesCsv != "":
+		names, err = graph.ModuleNames(modulesCsv)
+	case dsn != "":
+		names, err = moduleNamesPostgres(dsn)
+	default:
+		return errors.New("either a ModuleS CSV file or a DSN must be provided")
+	}
+	if err != nil {
+		return err
+	}
+
+	reconcileModules(names, all.modules).write(os.Stdout)
+
+	return nil
+}
+
+func moduleNamesPostgres(dsn string) ([]string, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`select distinct modname from equinox.modules order by modname;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// moduleReconciliation is the drift between ModuleS and the source tree.
+type moduleReconciliation struct {
+	matched int
+	// notInSource are ModuleS names with no module in the source
+	notInSource []string
+	// notRegistered are source modules with no ModuleS entry
+	notRegistered []module
+	// typeMismatches are ModuleS names whose extension does not match the
+	// type of the source module of the same name
+	typeMismatches []typeMismatch
+	// unresolved are ModuleS names that are truncated, or have an unknown
+	// extension, and do not match exactly one source module
+	unresolved []string
+}
+
+type typeMismatch struct {
+	name       string
+	sourceType moduleType
+}
+
+// reconciledTypes are the module types that can be registered in ModuleS,
+// that is, those with an extension known to mapModExt.
+var reconciledTypes = map[moduleType]struct{}{
+	mtExport: {},
+	mtForm:   {},
+	mtImport: {},
+	mtMethod: {},
+	mtQuery:  {},
+	mtReport: {},
+}
+
+func reconcileModules(names []string, modules []module) *moduleReconciliation {
+	r := &moduleReconciliation{}
+
+	inSource := make(map[module]struct{})
+	typesByName := make(map[string][]moduleType)
+	var sourceNames []string
+	for _, m := range modules {
+		if _, ok := reconciledTypes[m.moduleType]; !ok {
+			continue
+		}
+		inSource[m] = struct{}{}
+		if len(typesByName[m.moduleName]) == 0 {
+			sourceNames = append(sourceNames, m.moduleName)
+		}
+		typesByName[m.moduleName] = append(typesByName[m.moduleName], m.moduleType)
+	}
+	sort.Strings(sourceNames)
+
+	registered := make(map[module]struct{})
+	for _, name := range names {
+		base, ext, _ := strings.Cut(strings.ToLower(strings.TrimSpace(name)), ".")
+		mt, ok := lookupModExt(ext)
+		if !ok {
+			// Truncated, so match on the name alone, if that is unambiguous
+			var matches []module
+			i := sort.SearchStrings(sourceNames, base)
+			for ; i < len(sourceNames) && strings.HasPrefix(sourceNames[i], base); i++ {
+				for _, t := range typesByName[sourceNames[i]] {
+					matches = append(matches, module{sourceNames[i], t})
+				}
+			}
+			if len(matches) == 1 {
+				registered[matches[0]] = struct{}{}
+				r.matched++
+			} else {
+				r.unresolved = append(r.unresolved, name)
+			}
+			continue
+		}
+
+		m := module{base, mt}
+		if _, ok := inSource[m]; ok {
+			registered[m] = struct{}{}
+			r.matched++
+			continue
+		}
+		if types := typesByName[base]; len(types) != 0 {
+			for _, t := range types {
+				registered[module{base, t}] = struct{}{}
+				r.typeMismatches = append(r.typeMismatches, typeMismatch{name, t})
+			}
+			continue
+		}
+		r.notInSource = append(r.notInSource, name)
+	}
+
+	for _, m := range modules {
+		if _, ok := inSource[m]; !ok {
+			continue
+		}
+		if _, ok := registered[m]; !ok {
+			r.notRegistered = append(r.notRegistered, m)
+		}
+	}
+
+	sort.Strings(r.notInSource)
+	sort.Strings(r.unresolved)
+	sort.Slice(r.notRegistered, func(i, j int) bool {
+		if r.notRegistered[i].moduleType != r.notRegistered[j].moduleType {
+			return r.notRegistered[i].moduleType < r.notRegistered[j].moduleType
+		}
+		return r.notRegistered[i].moduleName < r.notRegistered[j].moduleName
+	})
+	sort.Slice(r.typeMismatches, func(i, j int) bool { return r.typeMismatches[i].name < r.typeMismatches[j].name })
+
+	return r
+}
+
+func (r *moduleReconciliation) write(w io.Writer) {
+	fmt.Fprintf(w, "matched : %d\n", r.matched)
+
+	fmt.Fprintf(w, "in ModuleS but not in source : %d\n", len(r.notInSource))
+	for _, name := range r.notInSource {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+
+	fmt.Fprintf(w, "in source but not in ModuleS : %d\n", len(r.notRegistered))
+	for _, m := range r.notRegistered {
+		fmt.Fprintf(w, "\t%s\t%s\n", m.moduleType, m.moduleName)
+	}
+
+	fmt.Fprintf(w, "type mismatches (ModuleS name, source type) : %d\n", len(r.typeMismatches))
+	for _, m := range r.typeMismatches {
+		fmt.Fprintf(w, "\t%s\t%s\n", m.name, m.sourceType)
+	}
+
+	fmt.Fprintf(w, "unresolved ModuleS names (truncated or unknown extension) : %d\n", len(r.unresolved))
+	for _, name := range r.unresolved {
+		fmt.Fprintf(w, "\t%s\n", name)
+	}
+}
@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileModules(t *testing.T) {
+	sweep := module{"nrg_sweep2", mtMethod}
+	sweep3 := module{"nrg_sweep3", mtMethod}
+	custForm := module{"custform", mtForm}
+	custRep := module{"custform", mtReport}
+
+	for _, tc := range []struct {
+		name    string
+		names   []string
+		modules []module
+		want    *moduleReconciliation
+	}{
+		{
+			name:    "matched",
+			names:   []string{"NRG_SWEEP2.jcl", " CustForm.FRM "},
+			modules: []module{sweep, custForm},
+			want:    &moduleReconciliation{matched: 2},
+		},
+		{
+			name:    "not in source",
+			names:   []string{"OLD.jcl", "NRG_SWEEP2.jcl", "GONE.frm"},
+			modules: []module{sweep},
+			want:    &moduleReconciliation{matched: 1, notInSource: []string{"GONE.frm", "OLD.jcl"}},
+		},
+		{
+			name:    "not registered",
+			names:   []string{"NRG_SWEEP2.jcl"},
+			modules: []module{sweep3, sweep, custForm, {"pp", mtProcedure}, {"proc", mtProcess}},
+			// Procedures and processes are never registered
+			want: &moduleReconciliation{matched: 1, notRegistered: []module{custForm, sweep3}},
+		},
+		{
+			name:    "type mismatch",
+			names:   []string{"NRG_SWEEP2.frm"},
+			modules: []module{sweep},
+			want:    &moduleReconciliation{typeMismatches: []typeMismatch{{"NRG_SWEEP2.frm", mtMethod}}},
+		},
+		{
+			name:    "truncated and unambiguous",
+			names:   []string{"NRG_SWEEP3", "CUSTFO", "NRG_SWEEP2.j"},
+			modules: []module{sweep, sweep3, custForm},
+			want:    &moduleReconciliation{matched: 3},
+		},
+		{
+			// A truncated extension means the name itself is whole
+			name:    "truncated extension",
+			names:   []string{"CUSTFO.f"},
+			modules: []module{custForm},
+			want:    &moduleReconciliation{notInSource: []string{"CUSTFO.f"}, notRegistered: []module{custForm}},
+		},
+		{
+			name:    "truncated and ambiguous",
+			names:   []string{"NRG_SWEEP", "CUSTFORM"},
+			modules: []module{sweep, sweep3, custForm, custRep},
+			want: &moduleReconciliation{
+				notRegistered: []module{custForm, sweep, sweep3, custRep},
+				unresolved:    []string{"CUSTFORM", "NRG_SWEEP"},
+			},
+		},
+		{
+			// Matched on the name alone, as for truncated names
+			name:    "unknown extension",
+			names:   []string{"CUSTFORM.xyz", "OTHER.xyz"},
+			modules: []module{custForm},
+			want:    &moduleReconciliation{matched: 1, unresolved: []string{"OTHER.xyz"}},
+		},
+		{
+			name:    "truncated and missing",
+			names:   []string{"ZZ"},
+			modules: []module{sweep},
+			want:    &moduleReconciliation{notRegistered: []module{sweep}, unresolved: []string{"ZZ"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, reconcileModules(tc.names, tc.modules))
+		})
+	}
+}
+
+func TestWriteModuleReconciliation(t *testing.T) {
+	r := &moduleReconciliation{
+		matched:        3,
+		notInSource:    []string{"OLD.jcl"},
+		notRegistered:  []module{{"custform", mtForm}},
+		typeMismatches: []typeMismatch{{"NRG_SWEEP2.frm", mtMethod}},
+		unresolved:     []string{"NRG_SWEEP"},
+	}
+
+	var buf bytes.Buffer
+	r.write(&buf)
+	assert.Equal(t, `matched : 3
+in ModuleS but not in source : 1
+	OLD.jcl
+in source but not in ModuleS : 1
+	form	custform
+type mismatches (ModuleS name, source type) : 1
+	NRG_SWEEP2.frm	method
+unresolved ModuleS names (truncated or unknown extension) : 1
+	NRG_SWEEP
+`, buf.String())
+}
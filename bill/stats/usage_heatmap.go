@@ -0,0 +1,294 @@
+package stats
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/utilitywarehouse/billsourcery/bill/graph"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// UsageHeatmapOptions say where ModuDet calls are read from, which are
+// included, and where the heatmap is written.
+type UsageHeatmapOptions struct {
+	// Dsn is the Bill Postgres mirror to read calls from, or else ...
+	Dsn string
+	// ... ModulesCsv and ModudetCsv are ModuleS and ModuDet CSV exports
+	ModulesCsv string
+	ModudetCsv string
+	// From and To limit the calls included to those dates, inclusive.  Zero
+	// values mean no limit.
+	From time.Time
+	To   time.Time
+	// Limit, if non zero, only includes this many modules, those least
+	// recently called
+	Limit int
+	// Output is the image file, the format is chosen by the extension
+	// (e.g. png or svg)
+	Output string
+	// OutputCsv, if set, is where to write the monthly counts as CSV
+	OutputCsv string
+}
+
+// UsageHeatmap renders the number of calls to each module per month, from
+// ModuDet, as a heatmap.  Modules are ordered by when they were last called,
+// so those trending to zero are at the top.  Only Client/Server calls are
+// included, as for calls-stats-table.
+func UsageHeatmap(opts UsageHeatmapOptions) error {
+	var counts map[string]map[time.Time]int
+	var err error
+	switch {
+	case opts.Dsn != "" && (opts.ModulesCsv != "" || opts.ModudetCsv != ""):
+		return errors.New("calls must come from either a DSN or CSV files, not both")
+	case opts.Dsn != "":
+		counts, err = callsByMonthPostgres(opts.Dsn, opts.From, opts.To)
+	case opts.ModulesCsv != "" && opts.ModudetCsv != "":
+		counts, err = graph.ModuleCallsByMonth(opts.ModulesCsv, opts.ModudetCsv, opts.From, opts.To)
+	default:
+		return errors.New("either a DSN or both module CSV files must be provided")
+	}
+	if err != nil {
+		return err
+	}
+	if len(counts) == 0 {
+		return errors.New("no calls found")
+	}
+
+	grid := newUsageGrid(counts, opts.Limit)
+
+	if opts.OutputCsv != "" {
+		if err := grid.writeCsv(opts.OutputCsv); err != nil {
+			return err
+		}
+		log.Printf("saved monthly counts to '%s'\n", opts.OutputCsv)
+	}
+
+	return grid.writeImage(opts.Output)
+}
+
+func callsByMonthPostgres(dsn string, from time.Time, to time.Time) (map[string]map[time.Time]int, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	q := `select
+		modname, date_trunc('month', dets.modudetdate)::date, count(*)
+		from equinox.modules mods
+		inner join equinox.modudet dets on mods.equinox_lrn = dets.equinox_prn and modudetsofttype='Client/Server'
+		where dets.modudetdate >= $1 and ($2::date is null or dets.modudetdate <= $2::date)
+		group by 1, 2
+		;
+	`
+
+	var until sql.NullTime
+	if !to.IsZero() {
+		until = sql.NullTime{Time: to, Valid: true}
+	}
+
+	rows, err := db.Query(q, from, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[time.Time]int)
+	for rows.Next() {
+		var name string
+		var month time.Time
+		var count int
+		if err := rows.Scan(&name, &month, &count); err != nil {
+			return nil, err
+		}
+		if counts[name] == nil {
+			counts[name] = make(map[time.Time]int)
+		}
+		counts[name][month.UTC()] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// usageGrid is the calls per module (row) per month (column), and
+// implements plotter.GridXYZ.
+type usageGrid struct {
+	modules []string
+	months  []time.Time
+	counts  [][]int
+}
+
+func newUsageGrid(counts map[string]map[time.Time]int, limit int) *usageGrid {
+	totals := make(map[string]int)
+	lastUsed := make(map[string]time.Time)
+	var first, last time.Time
+	for name, byMonth := range counts {
+		for month, c := range byMonth {
+			totals[name] += c
+			if month.After(lastUsed[name]) {
+				lastUsed[name] = month
+			}
+			if first.IsZero() || month.Before(first) {
+				first = month
+			}
+			if month.After(last) {
+				last = month
+			}
+		}
+	}
+
+	var modules []string
+	for name := range counts {
+		modules = append(modules, name)
+	}
+
+	// Least recently used first, then least used, so modules trending to
+	// zero stand out, and are kept by the limit
+	sort.Slice(modules, func(i, j int) bool {
+		a, b := modules[i], modules[j]
+		if !lastUsed[a].Equal(lastUsed[b]) {
+			return lastUsed[a].Before(lastUsed[b])
+		}
+		if totals[a] != totals[b] {
+			return totals[a] < totals[b]
+		}
+		return a < b
+	})
+	if limit > 0 && len(modules) > limit {
+		modules = modules[:limit]
+	}
+
+	g := &usageGrid{modules: modules}
+	for m := first; !m.After(last); m = m.AddDate(0, 1, 0) {
+		g.months = append(g.months, m)
+	}
+	for _, name := range modules {
+		row := make([]int, len(g.months))
+		for i, month := range g.months {
+			row[i] = counts[name][month]
+		}
+		g.counts = append(g.counts, row)
+	}
+	return g
+}
+
+func (g *usageGrid) Dims() (c, r int) { return len(g.months), len(g.modules) }
+
+// Z is on a log scale, so a few hot paths don't hide everything else.
+// Months with no calls are NaN, so they are left blank.
+func (g *usageGrid) Z(c, r int) float64 {
+	// Rows are plotted bottom up, but listed top down
+	count := g.counts[len(g.modules)-1-r][c]
+	if count == 0 {
+		return math.NaN()
+	}
+	return math.Log10(float64(count))
+}
+
+// Min and Max are the range of the palette, used by plotter.NewHeatMap in
+// place of the range of Z.  The range starts at a single call and is never
+// empty, as it would be if every month had the same number of calls, which
+// leaves every cell blank.
+func (g *usageGrid) Min() float64 { return 0 }
+
+func (g *usageGrid) Max() float64 {
+	maxCount := 1
+	for _, row := range g.counts {
+		for _, c := range row {
+			maxCount = max(maxCount, c)
+		}
+	}
+	return max(math.Log10(float64(maxCount)), 1)
+}
+
+func (g *usageGrid) X(c int) float64 { return float64(c) }
+
+func (g *usageGrid) Y(r int) float64 { return float64(r) }
+
+// labelTicker labels integer positions with names.
+type labelTicker []string
+
+func (lt labelTicker) Ticks(min float64, max float64) []plot.Tick {
+	var ticks []plot.Tick
+	for i, label := range lt {
+		if v := float64(i); v >= min && v <= max {
+			ticks = append(ticks, plot.Tick{Value: v, Label: label})
+		}
+	}
+	return ticks
+}
+
+func (g *usageGrid) writeImage(outfile string) error {
+	p := plot.New()
+
+	p.Title.Text = "Bill module calls per month (log scale)"
+
+	monthLabels := make(labelTicker, len(g.months))
+	for i, m := range g.months {
+		monthLabels[i] = m.Format("2006-01")
+	}
+	p.X.Label.Text = "Month"
+	p.X.Tick.Marker = monthLabels
+	p.X.Tick.Label.Rotation = math.Pi / 2
+	p.X.Tick.Label.XAlign = -1.2
+
+	moduleLabels := make(labelTicker, len(g.modules))
+	for i, name := range g.modules {
+		moduleLabels[len(g.modules)-1-i] = name
+	}
+	p.Y.Tick.Marker = moduleLabels
+
+	p.Add(plotter.NewHeatMap(g, palette.Heat(64, 1)))
+
+	width := vg.Length(max(30, 5+len(g.months)/2)) * vg.Centimeter
+	height := vg.Length(max(15, 5+len(g.modules)/3)) * vg.Centimeter
+	if err := p.Save(width, height, outfile); err != nil {
+		return err
+	}
+	log.Printf("saved output to '%s'\n", outfile)
+	return nil
+}
+
+func (g *usageGrid) writeCsv(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	header := []string{"module"}
+	for _, m := range g.months {
+		header = append(header, m.Format("2006-01"))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for i, name := range g.modules {
+		row := []string{name}
+		for _, c := range g.counts[i] {
+			row = append(row, strconv.Itoa(c))
+		}
+		if err := w.Write(row); err != nil {
+			return fmt.Errorf("failed to write %s : %w", filename, err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
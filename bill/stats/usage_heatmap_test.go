@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+)
+
+func month(y int, m time.Month) time.Time {
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNewUsageGrid(t *testing.T) {
+	assert := assert.New(t)
+
+	counts := map[string]map[time.Time]int{
+		"BUSY.jcl":      {month(2024, 1): 1000, month(2024, 4): 1000},
+		"QUIET.jcl":     {month(2024, 1): 1, month(2024, 4): 2},
+		"DECLINING.frm": {month(2024, 1): 50, month(2024, 2): 5},
+		"GONE.rep":      {month(2023, 11): 3},
+	}
+
+	g := newUsageGrid(counts, 0)
+	// Least recently used first, then least used
+	assert.Equal([]string{"GONE.rep", "DECLINING.frm", "QUIET.jcl", "BUSY.jcl"}, g.modules)
+	// Every month in between, including those without calls
+	assert.Equal([]time.Time{
+		month(2023, 11), month(2023, 12), month(2024, 1), month(2024, 2), month(2024, 3), month(2024, 4),
+	}, g.months)
+	assert.Equal([][]int{
+		{3, 0, 0, 0, 0, 0},
+		{0, 0, 50, 5, 0, 0},
+		{0, 0, 1, 0, 0, 2},
+		{0, 0, 1000, 0, 0, 1000},
+	}, g.counts)
+
+	c, r := g.Dims()
+	assert.Equal(6, c)
+	assert.Equal(4, r)
+	// Rows are plotted bottom up
+	assert.Equal(3.0, g.Z(2, 0))
+	assert.InDelta(math.Log10(3), g.Z(0, 3), 1e-9)
+	assert.True(math.IsNaN(g.Z(1, 3)))
+	assert.Equal(0.0, g.Min())
+	assert.Equal(3.0, g.Max())
+
+	// The limit keeps the declining modules, not the busiest
+	g = newUsageGrid(counts, 2)
+	assert.Equal([]string{"GONE.rep", "DECLINING.frm"}, g.modules)
+	// Still up to the last month, to show they have gone quiet
+	assert.Len(g.months, 6)
+	assert.Equal([]int{0, 0, 50, 5, 0, 0}, g.counts[1])
+}
+
+func TestUsageGridSameCounts(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newUsageGrid(map[string]map[time.Time]int{
+		"A.jcl": {month(2024, 1): 1, month(2024, 2): 1},
+		"B.jcl": {month(2024, 1): 1},
+	}, 0)
+
+	// Otherwise every cell would be painted as NaN
+	h := plotter.NewHeatMap(g, palette.Heat(64, 1))
+	assert.Less(h.Min, h.Max)
+	assert.Equal(0.0, g.Z(0, 0))
+}
+
+func TestUsageGridWriteCsv(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newUsageGrid(map[string]map[time.Time]int{
+		"A.jcl": {month(2024, 1): 7, month(2024, 3): 1},
+		"B.frm": {month(2024, 1): 2},
+	}, 0)
+
+	filename := filepath.Join(t.TempDir(), "usage.csv")
+	assert.NoError(g.writeCsv(filename))
+
+	b, err := os.ReadFile(filename)
+	assert.NoError(err)
+	assert.Equal("module,2024-01,2024-02,2024-03\nB.frm,2,0,0\nA.jcl,7,0,1\n", string(b))
+}